@@ -6,9 +6,11 @@ require (
 	github.com/aodin/date v0.0.0-20160219192542-c5f6146fc644
 	github.com/cockroachdb/errors v1.11.1
 	github.com/eapache/go-resiliency v1.4.0
+	github.com/fsnotify/fsnotify v1.6.0
 	github.com/getsentry/sentry-go v0.19.0
 	github.com/go-redis/cache/v8 v8.4.4
 	github.com/go-redis/redis/v8 v8.11.5
+	github.com/go-sql-driver/mysql v1.5.0
 	github.com/golang-migrate/migrate/v4 v4.16.2
 	github.com/google/go-cmp v0.5.9
 	github.com/google/go-cpy v0.0.0-20211218193943-a9c933c06932
@@ -18,16 +20,21 @@ require (
 	github.com/jackc/pgx/v4 v4.18.1
 	github.com/labstack/echo/v4 v4.11.1
 	github.com/leporo/sqlf v1.4.0
+	github.com/lib/pq v1.10.9
 	github.com/neoxelox/gilk v0.5.0
+	github.com/pmezard/go-difflib v1.0.0
+	github.com/prometheus/client_golang v1.17.0
 	github.com/randallmlough/pgxscan v0.3.0
 	github.com/rs/xid v1.5.0
 	github.com/rs/zerolog v1.30.0
 	github.com/scylladb/go-set v1.0.2
+	github.com/yuin/goldmark v1.7.8
 	golang.org/x/text v0.13.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
+	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/cockroachdb/logtags v0.0.0-20230118201751-21c54148d20b // indirect
 	github.com/cockroachdb/redact v1.1.5 // indirect
@@ -49,10 +56,13 @@ require (
 	github.com/kr/pretty v0.3.1 // indirect
 	github.com/kr/text v0.2.0 // indirect
 	github.com/labstack/gommon v0.4.0 // indirect
-	github.com/lib/pq v1.10.9 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.19 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.4 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
+	github.com/prometheus/client_model v0.4.1-0.20230718164431-9a2bf3000d16 // indirect
+	github.com/prometheus/common v0.44.0 // indirect
+	github.com/prometheus/procfs v0.11.1 // indirect
 	github.com/randallmlough/sqlmaper v0.0.0-20191117174101-7ad100a86097 // indirect
 	github.com/redis/go-redis/v9 v9.1.0 // indirect
 	github.com/robfig/cron/v3 v3.0.1 // indirect
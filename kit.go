@@ -31,6 +31,7 @@ var (
 	KeyBase                Key = "kit:"
 	KeyDatabaseTransaction Key = KeyBase + "database:transaction"
 	KeyLocalizerLocale     Key = KeyBase + "localizer:locale"
+	KeyLocalizerMissing    Key = KeyBase + "localizer:missing"
 	KeyTraceID             Key = KeyBase + "trace:id"
 )
 
@@ -68,6 +69,7 @@ var (
 	ErrObserverTimedOut           = NewError("observer timed out")
 	ErrSerializerGeneric          = NewError("serializer failed")
 	ErrRendererGeneric            = NewError("renderer failed")
+	ErrRendererTemplateNotFound   = NewError("renderer template not found")
 	ErrLocalizerGeneric           = NewError("localizer failed")
 	ErrServerGeneric              = NewError("server failed")
 	ErrServerTimedOut             = NewError("server timed out")
@@ -113,11 +115,23 @@ const (
 
 type _utils struct {
 	copier *cpy.Copier
+	sleep  func(time.Duration)
 }
 
 // Utils contains the builtin utils.
 var Utils = _utils{
 	copier: cpy.New(cpy.IgnoreAllUnexported(), cpy.Shallow(time.Time{}), cpy.Shallow(date.Date{})),
+	sleep:  time.Sleep,
+}
+
+// WithSleep returns the utils waiting with the given sleep function instead of the real time one,
+// e.g. to assert the retry backoff deterministically.
+func (self _utils) WithSleep(sleep func(time.Duration)) _utils {
+	if sleep != nil {
+		self.sleep = sleep
+	}
+
+	return self
 }
 
 func (self _utils) ByteSize(size int) string {
@@ -213,16 +227,7 @@ func (self _utils) Retry(
 		return nil
 	}
 
-	attempt := 1
-
-	// nolint
-	return retrier.New(retrier.ConstantBackoff(attempts, delay), classifier).
-		Run(func() error {
-			err := fn(attempt)
-			attempt++
-
-			return err
-		})
+	return self.retry(retrier.ConstantBackoff(attempts, delay), classifier, fn)
 }
 
 func (self _utils) ExponentialRetry(
@@ -234,16 +239,53 @@ func (self _utils) ExponentialRetry(
 		return nil
 	}
 
-	attempt := 1
+	return self.retry(retrier.LimitedExponentialBackoff(attempts, initialDelay, limitDelay), classifier, fn)
+}
+
+// retry runs the function retrying it with the backoff as the Go resiliency retrier does,
+// but waiting with the sleep function of the utils.
+func (self _utils) retry(backoff []time.Duration, classifier retrier.Classifier, fn func(attempt int) error) error {
+	if classifier == nil {
+		classifier = retrier.DefaultClassifier{}
+	}
 
-	// nolint
-	return retrier.New(retrier.LimitedExponentialBackoff(attempts, initialDelay, limitDelay), classifier).
-		Run(func() error {
-			err := fn(attempt)
-			attempt++
+	for attempt := 1; ; attempt++ {
+		err := fn(attempt)
 
+		if classifier.Classify(err) != retrier.Retry || attempt > len(backoff) {
 			return err
-		})
+		}
+
+		self.sleep(backoff[attempt-1])
+	}
+}
+
+func (self _utils) Levenshtein(first string, second string) int {
+	a, b := []rune(first), []rune(second)
+
+	distances := make([]int, len(b)+1)
+	for j := range distances {
+		distances[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		previous := distances[0]
+		distances[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			current := distances[j]
+
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			distances[j] = min(distances[j]+1, distances[j-1]+1, previous+cost)
+			previous = current
+		}
+	}
+
+	return distances[len(b)]
 }
 
 func (self _utils) Copy(src any) any {
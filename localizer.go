@@ -1,35 +1,133 @@
 package kit
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"html/template"
+	"io"
 	"io/fs"
-	"io/ioutil"
+	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/fsnotify/fsnotify"
+	"github.com/scylladb/go-set/strset"
+	"golang.org/x/text/currency"
+	"golang.org/x/text/feature/plural"
 	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
 	"gopkg.in/yaml.v3"
 )
 
 // TODO: enhance localization with go-i18n, go-localize or spreak
 
+const (
+	_LOCALIZER_FORMATS_SECTION  = "_formats"
+	_LOCALIZER_ORDINALS_SECTION = "_ordinals"
+	_LOCALIZER_EXTENDS_KEY      = "_extends"
+	_LOCALIZER_PLURAL_MAX_MOD   = 10000000
+	_LOCALIZER_CLOSEST_MATCHES  = 3
+)
+
+var (
+	_LOCALIZER_DEFAULT_LOCALES_PATH       = "./locales"
+	_LOCALIZER_DEFAULT_LOCALE_EXTENSIONS  = regexp.MustCompile(`^.*\.(yml|yaml|json|jsonc|json5)$`)
+	_LOCALIZER_DEFAULT_TRANSLATOR_TIMEOUT = 1 * time.Second
+	_LOCALIZER_WATCH_DEBOUNCE             = 100 * time.Millisecond
+	_LOCALIZER_DEFAULT_NIL_PLACEHOLDER    = ""
+	_LOCALIZER_FORMAT_ERROR               = regexp.MustCompile(`%!.?\(`)
+	_LOCALIZER_NAMED_PLACEHOLDER          = regexp.MustCompile(`\{(\w+)(?:\|default:((?:[^}\\]|\\.)*))?\}`)
+	_LOCALIZER_NAMED_ESCAPE               = regexp.MustCompile(`\\(.)`)
+	_LOCALIZER_PLURAL_FORMS               = map[plural.Form]string{
+		plural.Other: "OTHER",
+		plural.Zero:  "ZERO",
+		plural.One:   "ONE",
+		plural.Two:   "TWO",
+		plural.Few:   "FEW",
+		plural.Many:  "MANY",
+	}
+	_LOCALIZER_DEFAULT_TIME_FORMATS = map[string]string{
+		"SHORT":  "2006-01-02",
+		"MEDIUM": "Jan 2, 2006 15:04",
+		"LONG":   "Monday, January 2, 2006 15:04:05 MST",
+	}
+)
+
+type LocalizerKeyNormalization string
+
+// Builtin key normalizations.
 var (
-	_LOCALIZER_DEFAULT_LOCALES_PATH      = "./locales"
-	_LOCALIZER_DEFAULT_LOCALE_EXTENSIONS = regexp.MustCompile(`^.*\.(yml|yaml)$`)
+	LocalizerKeyNormalizationUpper LocalizerKeyNormalization = "upper"
+	LocalizerKeyNormalizationLower LocalizerKeyNormalization = "lower"
+	LocalizerKeyNormalizationNone  LocalizerKeyNormalization = "none"
 )
 
 type LocalizerConfig struct {
 	LocalesPath      *string
 	LocaleExtensions *regexp.Regexp
 	DefaultLocale    language.Tag
+	// Fallbacks are the locales whose copies are looked up, in order, when missing in a locale,
+	// before the default locale, which by default are the regional parents of the locale, such as
+	// es-419 and es for es-AR.
+	Fallbacks map[language.Tag][]language.Tag
+	// LocalesFS is the source of the locale files instead of LocalesPath when set,
+	// e.g. an embed.FS of the locales bundled in the binary.
+	LocalesFS fs.FS
+	// Translator is an optional machine-translation hook called with the default locale copy
+	// when a copy is missing in the requested locale. Results are cached in-memory until the
+	// next refresh. On error or timeout the default locale copy is used as usual.
+	Translator        func(ctx context.Context, from, to language.Tag, text string) (string, error)
+	TranslatorTimeout *time.Duration
+	// NilPlaceholder replaces the nil interpolation arguments, defaults to an empty string.
+	NilPlaceholder *string
+	// Transform is an optional hook applied to the final output of every Localize call,
+	// e.g. to wrap the copies in debug markers or to normalize typography.
+	Transform func(ctx context.Context, copy string, out string) string
+	// EmptyMissingNamed replaces the LocalizeNamed placeholders with neither argument nor default
+	// with an empty string instead of leaving them intact.
+	EmptyMissingNamed bool
+	// Strict makes LocalizeErr fail on the missing copies, e.g. to catch them in the tests,
+	// which otherwise localizes them leniently like Localize.
+	Strict bool
+	// OnMissing is an optional hook called with the locale missing the copy, once with the context
+	// locale when falling back to the default locale and once with the default locale when falling
+	// back to the copy itself, e.g. to record the untranslated copies.
+	OnMissing func(ctx context.Context, locale language.Tag, copy string)
+	// KeyNormalization is applied to the keys of the copies both when loading the locale files and
+	// when looking them up, which must match, upper by default. With none, the keys of the reserved
+	// sections, plural forms and time styles must be authored lowercase, like _formats.short.
+	KeyNormalization *LocalizerKeyNormalization
+}
+
+type _localizerMissing struct {
+	mutex  sync.Mutex
+	copies []string
+}
+
+type _localizerTranslation struct {
+	locale language.Tag
+	copy   string
 }
 
 type Localizer struct {
-	config   LocalizerConfig
-	observer Observer
-	copies   *map[language.Tag]map[string]string
+	config       LocalizerConfig
+	observer     Observer
+	mutex        *sync.RWMutex
+	copies       *map[language.Tag]map[string]string
+	locales      *[]language.Tag
+	matcher      *language.Matcher
+	translations *sync.Map
+	parents      *map[language.Tag]language.Tag
+	fallbacks    *map[language.Tag][]language.Tag
 }
 
 func NewLocalizer(observer Observer, config LocalizerConfig) (*Localizer, error) {
@@ -39,26 +137,56 @@ func NewLocalizer(observer Observer, config LocalizerConfig) (*Localizer, error)
 
 	config.LocaleExtensions = _LOCALIZER_DEFAULT_LOCALE_EXTENSIONS.Copy()
 
+	if config.TranslatorTimeout == nil {
+		config.TranslatorTimeout = ptr(_LOCALIZER_DEFAULT_TRANSLATOR_TIMEOUT)
+	}
+
+	if config.NilPlaceholder == nil {
+		config.NilPlaceholder = ptr(_LOCALIZER_DEFAULT_NIL_PLACEHOLDER)
+	}
+
+	if config.KeyNormalization == nil {
+		config.KeyNormalization = ptr(LocalizerKeyNormalizationUpper)
+	}
+
 	*config.LocalesPath = filepath.Clean(*config.LocalesPath)
 
-	copiesByLang, err := _getCopies(&observer, *config.LocalesPath, config.LocaleExtensions)
+	if config.LocalesFS == nil {
+		config.LocalesFS = os.DirFS(*config.LocalesPath)
+	}
+
+	copiesByLang, err := _getCopies(&observer, config.LocalesFS, config.LocaleExtensions, *config.KeyNormalization)
+	if err != nil {
+		return nil, ErrLocalizerGeneric().Wrap(err)
+	}
+
+	parents, err := _extendCopies(copiesByLang)
 	if err != nil {
 		return nil, ErrLocalizerGeneric().Wrap(err)
 	}
 
+	locales, matcher := _getMatcher(config.DefaultLocale, copiesByLang)
+	fallbacks := _getFallbacks(config, copiesByLang)
+
 	return &Localizer{
-		config:   config,
-		observer: observer,
-		copies:   copiesByLang,
+		config:       config,
+		observer:     observer,
+		mutex:        &sync.RWMutex{},
+		copies:       copiesByLang,
+		locales:      &locales,
+		matcher:      &matcher,
+		translations: &sync.Map{},
+		parents:      &parents,
+		fallbacks:    &fallbacks,
 	}, nil
 }
 
 func _getCopies(
-	observer *Observer, localesPath string,
-	localeExtensions *regexp.Regexp) (*map[language.Tag]map[string]string, error) {
+	observer *Observer, localesFS fs.FS, localeExtensions *regexp.Regexp,
+	normalization LocalizerKeyNormalization) (*map[language.Tag]map[string]string, error) {
 	copiesByLang := make(map[language.Tag]map[string]string)
 
-	err := filepath.WalkDir(localesPath, func(path string, info fs.DirEntry, err error) error {
+	err := fs.WalkDir(localesFS, ".", func(path string, info fs.DirEntry, err error) error {
 		if err != nil {
 			return ErrLocalizerGeneric().WrapAs(err)
 		}
@@ -71,26 +199,17 @@ func _getCopies(
 			return nil
 		}
 
-		lang, err := language.Parse(info.Name()[:len(info.Name())-len(filepath.Ext(info.Name()))])
-		if err != nil {
-			return nil // nolint
-		}
-
-		file, err := ioutil.ReadFile(path)
-		if err != nil {
-			return ErrLocalizerGeneric().WrapAs(err)
+		lang, ok := _getFileLocale(path)
+		if !ok {
+			return nil
 		}
 
-		copies := make(map[string]string)
-
-		err = yaml.Unmarshal(file, &copies)
+		copies, err := _getLocaleCopies(localesFS, path, lang, normalization)
 		if err != nil {
 			return ErrLocalizerGeneric().WrapAs(err)
 		}
 
-		copiesByLang[lang] = copies
-
-		return nil
+		return _addLocaleCopies(copiesByLang, lang, copies, path)
 	})
 	if err != nil {
 		return nil, ErrLocalizerGeneric().Wrap(err)
@@ -113,13 +232,567 @@ func _getCopies(
 	return &copiesByLang, nil
 }
 
+func _normalizeKey(normalization LocalizerKeyNormalization, key string) string {
+	switch normalization {
+	case LocalizerKeyNormalizationLower:
+		return strings.ToLower(key)
+	case LocalizerKeyNormalizationNone:
+		return key
+	default:
+		return strings.ToUpper(key)
+	}
+}
+
+// _getFileLocale returns the locale of the file, named after the file itself, such as old/en.yml,
+// or else after its directory in the directory-per-locale layout, such as en/emails.yml.
+func _getFileLocale(path string) (language.Tag, bool) {
+	name := filepath.Base(path)
+
+	if lang, err := language.Parse(name[:len(name)-len(filepath.Ext(name))]); err == nil {
+		return lang, true
+	}
+
+	if dir := filepath.Dir(path); dir != "." {
+		if lang, err := language.Parse(filepath.Base(dir)); err == nil {
+			return lang, true
+		}
+	}
+
+	return language.Und, false
+}
+
+// _addLocaleCopies merges the copies of a file into the ones of its locale loaded from the other files,
+// failing on the copies declared by more than one of them.
+func _addLocaleCopies(
+	copiesByLang map[language.Tag]map[string]string, lang language.Tag, copies map[string]string, path string) error {
+	existing, ok := copiesByLang[lang]
+	if !ok {
+		copiesByLang[lang] = copies
+		return nil
+	}
+
+	for key, copy := range copies { // nolint
+		if _, ok := existing[key]; ok {
+			return ErrLocalizerGeneric().Withf("copy %s of locale %s is duplicated in %s", key, lang, path)
+		}
+
+		existing[key] = copy
+	}
+
+	return nil
+}
+
+func _getLocaleCopies(
+	localesFS fs.FS, path string, lang language.Tag, normalization LocalizerKeyNormalization) (map[string]string, error) {
+	file, err := fs.ReadFile(localesFS, path)
+	if err != nil {
+		return nil, ErrLocalizerGeneric().WrapAs(err)
+	}
+
+	values := make(map[string]any)
+
+	switch filepath.Ext(path) {
+	case ".json":
+		err = json.Unmarshal(file, &values)
+	case ".jsonc", ".json5":
+		err = json.Unmarshal(_stripJSONComments(file), &values)
+	default:
+		err = yaml.Unmarshal(file, &values)
+	}
+	if err != nil {
+		return nil, ErrLocalizerGeneric().WrapAs(err)
+	}
+
+	copies := make(map[string]string, len(values))
+
+	for key, value := range values {
+		if key == _LOCALIZER_EXTENDS_KEY {
+			parent, ok := value.(string)
+			if !ok {
+				return nil, ErrLocalizerGeneric().Withf("extends of locale %s is not a locale", lang)
+			}
+
+			copies[key] = parent
+			continue
+		}
+
+		key = _normalizeKey(normalization, key)
+
+		switch value := value.(type) {
+		case nil:
+			copies[key] = ""
+		case map[string]any:
+			err = _flattenCopies(copies, key, value, lang, normalization)
+			if err != nil {
+				return nil, err
+			}
+		case []any:
+			return nil, ErrLocalizerGeneric().Withf("copy %s of locale %s is not a string", key, lang)
+		default:
+			copies[key] = fmt.Sprint(value)
+		}
+	}
+
+	return copies, nil
+}
+
+// _flattenCopies stores the nested copies, such as the reserved sections, the plural copies or
+// any group of copies, as regular copies under their normalized dot-joined keys, like ERRORS.NOT_FOUND.
+func _flattenCopies(copies map[string]string, prefix string, values map[string]any,
+	lang language.Tag, normalization LocalizerKeyNormalization) error {
+	for name, value := range values {
+		key := _normalizeKey(normalization, prefix+"."+name)
+
+		switch value := value.(type) {
+		case nil:
+			copies[key] = ""
+		case map[string]any:
+			err := _flattenCopies(copies, key, value, lang, normalization)
+			if err != nil {
+				return err
+			}
+		case []any:
+			return ErrLocalizerGeneric().Withf("copy %s of locale %s is not a string", key, lang)
+		default:
+			copies[key] = fmt.Sprint(value)
+		}
+	}
+
+	return nil
+}
+
+// _stripJSONComments strips the line and block comments and the trailing commas of JSONC and
+// JSON5 documents, leaving the contents of the strings untouched.
+func _stripJSONComments(data []byte) []byte {
+	stripped := make([]byte, 0, len(data))
+
+	for i := 0; i < len(data); i++ {
+		switch {
+		case data[i] == '"' || data[i] == '\'':
+			quote := data[i]
+			start := i
+
+			for i++; i < len(data) && data[i] != quote; i++ {
+				if data[i] == '\\' {
+					i++
+				}
+			}
+
+			stripped = append(stripped, data[start:min(i+1, len(data))]...)
+		case data[i] == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+
+			stripped = append(stripped, '\n')
+		case data[i] == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return stripped
+			}
+
+			i += end + 3
+			stripped = append(stripped, ' ')
+		case data[i] == ',':
+			// Trailing commas are followed only by whitespace and comments until the closing bracket
+			next := _skipJSONSpace(data, i+1)
+			if next < len(data) && (data[next] == '}' || data[next] == ']') {
+				continue
+			}
+
+			stripped = append(stripped, ',')
+		default:
+			stripped = append(stripped, data[i])
+		}
+	}
+
+	return stripped
+}
+
+// _skipJSONSpace returns the index of the first byte from i that is neither whitespace nor a comment.
+func _skipJSONSpace(data []byte, i int) int {
+	for i < len(data) {
+		switch {
+		case data[i] == ' ' || data[i] == '\t' || data[i] == '\r' || data[i] == '\n':
+			i++
+		case bytes.HasPrefix(data[i:], []byte("//")):
+			end := bytes.IndexByte(data[i:], '\n')
+			if end < 0 {
+				return len(data)
+			}
+
+			i += end
+		case bytes.HasPrefix(data[i:], []byte("/*")):
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return len(data)
+			}
+
+			i += end + 4
+		default:
+			return i
+		}
+	}
+
+	return i
+}
+
+// _extendCopies merges in place the copies of the parent locales, declared with _extends, under the
+// copies of their child locales, which win on conflict, returning the parent of every child locale.
+func _extendCopies(copiesByLang *map[language.Tag]map[string]string) (map[language.Tag]language.Tag, error) {
+	parents := make(map[language.Tag]language.Tag)
+
+	for lang, copies := range *copiesByLang {
+		if extends, ok := copies[_LOCALIZER_EXTENDS_KEY]; ok {
+			parent, err := language.Parse(extends)
+			if err != nil {
+				return nil, ErrLocalizerGeneric().Withf("extends %s of locale %s is not a locale", extends, lang)
+			}
+
+			parents[lang] = parent
+		}
+	}
+
+	extended := make(map[language.Tag]bool, len(*copiesByLang))
+
+	var extend func(lang language.Tag, chain []language.Tag) error
+	extend = func(lang language.Tag, chain []language.Tag) error {
+		parent, ok := parents[lang]
+		if !ok || extended[lang] {
+			return nil
+		}
+
+		for _, ancestor := range chain {
+			if ancestor == lang {
+				return ErrLocalizerGeneric().Withf("locale %s extends itself through a cycle", lang)
+			}
+		}
+
+		if _, ok := (*copiesByLang)[parent]; !ok {
+			return ErrLocalizerGeneric().Withf("locale %s extends missing locale %s", lang, parent)
+		}
+
+		err := extend(parent, append(chain, lang))
+		if err != nil {
+			return err
+		}
+
+		(*copiesByLang)[lang] = _mergeCopies((*copiesByLang)[parent], (*copiesByLang)[lang])
+		extended[lang] = true
+
+		return nil
+	}
+
+	for lang := range parents {
+		err := extend(lang, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return parents, nil
+}
+
+// _mergeCopies returns the copies of the child over the ones of the parent, without the extends.
+func _mergeCopies(parent map[string]string, child map[string]string) map[string]string {
+	copies := make(map[string]string, len(parent)+len(child))
+
+	for key, copy := range parent { // nolint
+		copies[key] = copy
+	}
+
+	for key, copy := range child { // nolint
+		copies[key] = copy
+	}
+
+	delete(copies, _LOCALIZER_EXTENDS_KEY)
+
+	return copies
+}
+
+// _getMatcher builds the matcher over the loaded locales, with the default locale
+// first so it is used as the fallback of the negotiation.
+func _getMatcher(
+	defaultLocale language.Tag,
+	copiesByLang *map[language.Tag]map[string]string) ([]language.Tag, language.Matcher) {
+	locales := make([]language.Tag, 0, len(*copiesByLang)+1)
+	locales = append(locales, defaultLocale)
+
+	for lang := range *copiesByLang {
+		if lang != defaultLocale {
+			locales = append(locales, lang)
+		}
+	}
+
+	sort.Slice(locales[1:], func(i, j int) bool {
+		return locales[i+1].String() < locales[j+1].String()
+	})
+
+	return locales, language.NewMatcher(locales)
+}
+
+// _getFallbacks precomputes the fallback chains of the loaded locales and of the configured ones.
+func _getFallbacks(
+	config LocalizerConfig, copiesByLang *map[language.Tag]map[string]string) map[language.Tag][]language.Tag {
+	fallbacks := make(map[language.Tag][]language.Tag, len(*copiesByLang)+len(config.Fallbacks))
+
+	for locale := range *copiesByLang {
+		fallbacks[locale] = _getFallbackChain(config, locale)
+	}
+
+	for locale := range config.Fallbacks {
+		fallbacks[locale] = _getFallbackChain(config, locale)
+	}
+
+	return fallbacks
+}
+
+// _getFallbackChain returns the locale followed by its configured fallbacks or else its regional parents.
+func _getFallbackChain(config LocalizerConfig, locale language.Tag) []language.Tag {
+	chain := []language.Tag{locale}
+
+	if fallbacks, ok := config.Fallbacks[locale]; ok {
+		return append(chain, fallbacks...)
+	}
+
+	for parent := locale.Parent(); !parent.IsRoot(); parent = parent.Parent() {
+		chain = append(chain, parent)
+	}
+
+	return chain
+}
+
+// Refresh reloads the locales in place, so copies of this localizer also observe them.
 func (self *Localizer) Refresh() error {
-	copiesByLang, err := _getCopies(&self.observer, *self.config.LocalesPath, self.config.LocaleExtensions)
+	copiesByLang, err := _getCopies(
+		&self.observer, self.config.LocalesFS, self.config.LocaleExtensions, *self.config.KeyNormalization)
+	if err != nil {
+		return ErrLocalizerGeneric().Wrap(err)
+	}
+
+	parents, err := _extendCopies(copiesByLang)
 	if err != nil {
 		return ErrLocalizerGeneric().Wrap(err)
 	}
 
-	self.copies = copiesByLang
+	locales, matcher := _getMatcher(self.config.DefaultLocale, copiesByLang)
+	fallbacks := _getFallbacks(self.config, copiesByLang)
+
+	self.mutex.Lock()
+	*self.copies = *copiesByLang
+	*self.locales = locales
+	*self.matcher = matcher
+	*self.parents = parents
+	*self.fallbacks = fallbacks
+	self.mutex.Unlock()
+
+	self.translations.Range(func(key any, _ any) bool {
+		self.translations.Delete(key)
+		return true
+	})
+
+	return nil
+}
+
+// RefreshLocale reloads in place only the copies of the given locale, and of the locales extending it,
+// leaving the other locales untouched. When the file of the locale no longer exists, the copies of the
+// locale are removed.
+func (self *Localizer) RefreshLocale(locale language.Tag) error {
+	copiesByLang := make(map[language.Tag]map[string]string, 1)
+
+	err := fs.WalkDir(self.config.LocalesFS, ".", func(path string, info fs.DirEntry, err error) error {
+		if err != nil {
+			return ErrLocalizerGeneric().WrapAs(err)
+		}
+
+		if info.IsDir() || !self.config.LocaleExtensions.MatchString(info.Name()) {
+			return nil
+		}
+
+		lang, ok := _getFileLocale(path)
+		if !ok || lang != locale {
+			return nil
+		}
+
+		copies, err := _getLocaleCopies(self.config.LocalesFS, path, lang, *self.config.KeyNormalization)
+		if err != nil {
+			return ErrLocalizerGeneric().WrapAs(err)
+		}
+
+		return _addLocaleCopies(copiesByLang, lang, copies, path)
+	})
+	if err != nil {
+		return ErrLocalizerGeneric().Wrap(err)
+	}
+
+	copies := copiesByLang[locale]
+
+	self.mutex.Lock()
+
+	delete(*self.parents, locale)
+
+	if extends, ok := copies[_LOCALIZER_EXTENDS_KEY]; ok {
+		parent, err := language.Parse(extends)
+		if err != nil {
+			self.mutex.Unlock()
+			return ErrLocalizerGeneric().Withf("extends %s of locale %s is not a locale", extends, locale)
+		}
+
+		for ancestor, ok := parent, true; ok; ancestor, ok = (*self.parents)[ancestor] {
+			if ancestor == locale {
+				self.mutex.Unlock()
+				return ErrLocalizerGeneric().Withf("locale %s extends itself through a cycle", locale)
+			}
+		}
+
+		if _, ok := (*self.copies)[parent]; !ok {
+			self.mutex.Unlock()
+			return ErrLocalizerGeneric().Withf("locale %s extends missing locale %s", locale, parent)
+		}
+
+		copies = _mergeCopies((*self.copies)[parent], copies)
+		(*self.parents)[locale] = parent
+	}
+
+	if copies != nil {
+		(*self.copies)[locale] = copies
+		(*self.fallbacks)[locale] = _getFallbackChain(self.config, locale)
+		self.observer.Infof(context.Background(), "Reloaded locale %s", locale)
+	} else {
+		delete(*self.copies, locale)
+		self.observer.Infof(context.Background(), "Removed locale %s", locale)
+	}
+
+	*self.locales, *self.matcher = _getMatcher(self.config.DefaultLocale, self.copies)
+
+	children := make([]language.Tag, 0)
+	for child, parent := range *self.parents {
+		if parent == locale {
+			children = append(children, child)
+		}
+	}
+
+	self.mutex.Unlock()
+
+	// Translations of any locale are made from the default locale copies
+	self.translations.Range(func(key any, _ any) bool {
+		if locale == self.config.DefaultLocale || key.(_localizerTranslation).locale == locale {
+			self.translations.Delete(key)
+		}
+
+		return true
+	})
+
+	for _, child := range children {
+		err = self.RefreshLocale(child)
+		if err != nil {
+			return ErrLocalizerGeneric().Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// Watch refreshes the locales whenever the files under the LocalesPath are created, written or removed,
+// debouncing bursts of events into a single refresh, until the context is cancelled. Intended for
+// development, it watches the LocalesPath even when the locales are loaded from the LocalesFS.
+func (self *Localizer) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return ErrLocalizerGeneric().Wrap(err)
+	}
+	defer watcher.Close()
+
+	// Subdirectories are not watched recursively, so each one is added
+	err = filepath.WalkDir(*self.config.LocalesPath, func(path string, info fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return watcher.Add(path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return ErrLocalizerGeneric().Wrap(err)
+	}
+
+	var refresh <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+
+			if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) &&
+				!event.Has(fsnotify.Remove) && !event.Has(fsnotify.Rename) {
+				continue
+			}
+
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					err = watcher.Add(event.Name)
+					if err != nil {
+						self.observer.Warnf(ctx, "Cannot watch locales directory %s: %v", event.Name, err)
+					}
+				}
+			}
+
+			refresh = time.After(_LOCALIZER_WATCH_DEBOUNCE)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+
+			self.observer.Warnf(ctx, "Cannot watch locales: %v", err)
+		case <-refresh:
+			refresh = nil
+
+			err := self.Refresh()
+			if err != nil {
+				self.observer.Errorf(ctx, "Cannot reload locales: %v", err)
+				continue
+			}
+
+			self.observer.Info(ctx, "Reloaded locales")
+		}
+	}
+}
+
+// Export writes the loaded copies of the locale to the writer, in the yaml or json format, sorted
+// by key. Reserved sections and plural copies are written flattened, which loads back the same.
+func (self Localizer) Export(locale language.Tag, w io.Writer, format string) error {
+	self.mutex.RLock()
+	copies, ok := (*self.copies)[locale]
+	self.mutex.RUnlock()
+
+	if !ok {
+		return ErrLocalizerGeneric().Withf("locale %s is not loaded", locale)
+	}
+
+	var err error
+
+	switch strings.ToLower(format) {
+	case "yaml", "yml":
+		encoder := yaml.NewEncoder(w)
+		err = Utils.CombineErrors(encoder.Encode(copies), encoder.Close())
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(copies)
+	default:
+		return ErrLocalizerGeneric().Withf("export format %s is not supported", format)
+	}
+	if err != nil {
+		return ErrLocalizerGeneric().Wrap(err)
+	}
 
 	return nil
 }
@@ -136,16 +809,687 @@ func (self Localizer) GetLocale(ctx context.Context) language.Tag {
 	return self.config.DefaultLocale
 }
 
+func (self Localizer) normalize(copy string) string { // nolint
+	return _normalizeKey(*self.config.KeyNormalization, copy)
+}
+
+// find looks up the copy in the locale and then in its fallbacks, returning the first one found.
+// Must be called with the mutex held.
+func (self Localizer) find(locale language.Tag, copy string) (string, bool) { // nolint
+	chain, ok := (*self.fallbacks)[locale]
+	if !ok {
+		chain = _getFallbackChain(self.config, locale)
+	}
+
+	for _, fallback := range chain {
+		if trans, ok := (*self.copies)[fallback][copy]; ok {
+			return trans, true
+		}
+	}
+
+	return "", false
+}
+
+// WithMissingKeyCollector returns a context collecting the copies that could not be localized
+// with it, neither in its locale nor in the default one, e.g. to assert there are none in tests.
+func (self Localizer) WithMissingKeyCollector(ctx context.Context) context.Context {
+	return context.WithValue(ctx, KeyLocalizerMissing, &_localizerMissing{})
+}
+
+// MissingKeys returns the copies collected by the missing key collector of the context, in order.
+func (self Localizer) MissingKeys(ctx context.Context) []string {
+	collector, ok := ctx.Value(KeyLocalizerMissing).(*_localizerMissing)
+	if !ok {
+		return nil
+	}
+
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
+	return append([]string(nil), collector.copies...)
+}
+
+func (self Localizer) missing(ctx context.Context, copy string) { // nolint
+	collector, ok := ctx.Value(KeyLocalizerMissing).(*_localizerMissing)
+	if !ok {
+		return
+	}
+
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
+	for _, missing := range collector.copies {
+		if missing == copy {
+			return
+		}
+	}
+
+	collector.copies = append(collector.copies, copy)
+}
+
 func (self Localizer) Localize(ctx context.Context, copy string, i ...any) string { // nolint
-	copy = strings.ToUpper(copy) // nolint
+	copy = self.normalize(copy) // nolint
+	out := self.localize(ctx, copy, i...)
+
+	if self.config.Transform != nil {
+		return self.config.Transform(ctx, copy, out)
+	}
+
+	return out
+}
+
+// LocalizeStrict localizes the copy like Localize but fails when the copy is missing in both the
+// context and the default locales, suggesting the closest existing copies.
+func (self Localizer) LocalizeStrict(ctx context.Context, copy string, i ...any) (string, error) { // nolint
+	copy = self.normalize(copy) // nolint
+	locale := self.GetLocale(ctx)
+
+	self.mutex.RLock()
+	_, ok := self.find(locale, copy)
+	_, okD := (*self.copies)[self.config.DefaultLocale][copy]
+	self.mutex.RUnlock()
+
+	if !ok && !okD {
+		return "", ErrLocalizerGeneric().Withf("copy %s not found, did you mean %s?",
+			copy, strings.Join(self.closest(locale, copy), ", "))
+	}
 
-	if trans, ok := (*self.copies)[self.GetLocale(ctx)][copy]; ok {
-		return fmt.Sprintf(trans, i...)
+	return self.Localize(ctx, copy, i...), nil
+}
+
+// LocalizeErr localizes the copy like LocalizeStrict when Strict is set, or else like Localize.
+func (self Localizer) LocalizeErr(ctx context.Context, copy string, i ...any) (string, error) { // nolint
+	if !self.config.Strict {
+		return self.Localize(ctx, copy, i...), nil
+	}
+
+	return self.LocalizeStrict(ctx, copy, i...)
+}
+
+// closest returns the existing copies of the locale and of the default locale closest to the copy.
+func (self Localizer) closest(locale language.Tag, copy string) []string {
+	self.mutex.RLock()
+	copies := strset.New()
+	for key := range (*self.copies)[locale] {
+		copies.Add(key)
+	}
+	for key := range (*self.copies)[self.config.DefaultLocale] {
+		copies.Add(key)
+	}
+	self.mutex.RUnlock()
+
+	matches := copies.List()
+
+	sort.Strings(matches)
+	sort.SliceStable(matches, func(i, j int) bool {
+		return Utils.Levenshtein(copy, matches[i]) < Utils.Levenshtein(copy, matches[j])
+	})
+
+	return matches[:min(len(matches), _LOCALIZER_CLOSEST_MATCHES)]
+}
+
+// LocalizeHTML localizes the copy as HTML, so the markup of the copy is not escaped when embedded
+// in an html/template. The copies are trusted as HTML, so they must only come from the translators,
+// while the string, fmt.Stringer and error arguments are escaped unless they are template.HTML.
+func (self Localizer) LocalizeHTML(ctx context.Context, copy string, i ...any) template.HTML { // nolint
+	escaped := make([]any, len(i))
+
+	for j, arg := range i {
+		if _isNil(arg) {
+			escaped[j] = arg
+			continue
+		}
+
+		switch arg := arg.(type) {
+		case template.HTML:
+			escaped[j] = string(arg)
+		case string:
+			escaped[j] = template.HTMLEscapeString(arg)
+		case fmt.Stringer:
+			escaped[j] = template.HTMLEscapeString(arg.String())
+		case error:
+			escaped[j] = template.HTMLEscapeString(arg.Error())
+		default:
+			escaped[j] = arg
+		}
+	}
+
+	return template.HTML(self.Localize(ctx, copy, escaped...)) // nolint
+}
+
+// LocalizeMany localizes all the copies at once looking them up under a single read lock,
+// returning them by the given copies.
+func (self Localizer) LocalizeMany(ctx context.Context, copies []string) map[string]string {
+	locale := self.GetLocale(ctx)
+
+	type lookup struct {
+		copy   string
+		trans  string
+		ok     bool
+		transD string
+		okD    bool
+	}
+
+	lookups := make([]lookup, len(copies))
+
+	self.mutex.RLock()
+	for j, copy := range copies { // nolint
+		copy = self.normalize(copy) // nolint
+		trans, ok := self.find(locale, copy)
+		transD, okD := (*self.copies)[self.config.DefaultLocale][copy]
+		lookups[j] = lookup{copy: copy, trans: trans, ok: ok, transD: transD, okD: okD}
 	}
+	self.mutex.RUnlock()
+
+	outs := make(map[string]string, len(copies))
+
+	for j, lookup := range lookups {
+		out := self.localizeCopy(ctx, locale, lookup.copy, lookup.trans, lookup.ok, lookup.transD, lookup.okD)
 
-	if trans, ok := (*self.copies)[self.config.DefaultLocale][copy]; ok {
-		return fmt.Sprintf(trans, i...)
+		if self.config.Transform != nil {
+			out = self.config.Transform(ctx, lookup.copy, out)
+		}
+
+		outs[copies[j]] = out
 	}
 
+	return outs
+}
+
+// LocalizeIn localizes the copy in the given locale regardless of the context locale,
+// e.g. to show the same copy side by side in several languages.
+func (self Localizer) LocalizeIn(locale language.Tag, copy string, i ...any) string { // nolint
+	return self.Localize(self.SetLocale(context.Background(), locale), copy, i...)
+}
+
+func (self Localizer) localize(ctx context.Context, copy string, i ...any) string { // nolint
+	locale := self.GetLocale(ctx)
+
+	self.mutex.RLock()
+	trans, ok := self.find(locale, copy)
+	transD, okD := (*self.copies)[self.config.DefaultLocale][copy]
+	self.mutex.RUnlock()
+
+	return self.localizeCopy(ctx, locale, copy, trans, ok, transD, okD, i...)
+}
+
+// localizeCopy localizes the already looked up copy of the locale and of the default locale.
+func (self Localizer) localizeCopy(ctx context.Context, locale language.Tag, copy string, // nolint
+	trans string, ok bool, transD string, okD bool, i ...any) string {
+	self.notify(ctx, locale, copy, ok, okD)
+
+	i = self.args(i)
+
+	if ok {
+		if out, ok := self.format(ctx, locale, copy, trans, i); ok {
+			return out
+		}
+	}
+
+	if okD {
+		if !ok && self.config.Translator != nil && locale != self.config.DefaultLocale {
+			if translated, ok := self.translate(ctx, locale, copy, transD); ok {
+				if out, ok := self.format(ctx, locale, copy, translated, i); ok {
+					return out
+				}
+			}
+		}
+
+		if out, ok := self.format(ctx, self.config.DefaultLocale, copy, transD, i); ok {
+			return out
+		}
+	}
+
+	// Every copy mismatched its arguments, return the unformatted one rather than a corrupted one
+	if ok {
+		return trans
+	}
+
+	if okD {
+		return transD
+	}
+
+	self.missing(ctx, copy)
+
 	return copy
 }
+
+// notify calls the OnMissing hook for the context and default locales missing the copy.
+func (self Localizer) notify(ctx context.Context, locale language.Tag, copy string, ok bool, okD bool) { // nolint
+	if self.config.OnMissing == nil {
+		return
+	}
+
+	if !ok {
+		self.config.OnMissing(ctx, locale, copy)
+	}
+
+	if !okD && locale != self.config.DefaultLocale {
+		self.config.OnMissing(ctx, self.config.DefaultLocale, copy)
+	}
+}
+
+// format applies the arguments to the copy, failing if any formatting error artifact is produced.
+func (self Localizer) format(
+	ctx context.Context, locale language.Tag, copy string, trans string, i []any) (string, bool) { // nolint
+	out := fmt.Sprintf(trans, i...)
+
+	if _LOCALIZER_FORMAT_ERROR.MatchString(out) && !_LOCALIZER_FORMAT_ERROR.MatchString(trans) {
+		self.observer.Warnf(ctx, "Copy %s of locale %s mismatches its arguments: %s", copy, locale, out)
+		return "", false
+	}
+
+	return out, true
+}
+
+// _nilPlaceholder formats as the nil placeholder under any verb,
+// so a nil argument of a %d copy does not become %!d(string=).
+type _nilPlaceholder string
+
+func (self _nilPlaceholder) Format(state fmt.State, verb rune) {
+	state.Write([]byte(self)) // nolint
+}
+
+// args replaces the nil interpolation arguments with the nil placeholder,
+// so Go formatting artifacts like %!s(<nil>) never reach the user.
+func (self Localizer) args(i []any) []any {
+	var args []any
+
+	for index, arg := range i {
+		if !_isNil(arg) {
+			continue
+		}
+
+		if args == nil {
+			args = make([]any, len(i))
+			copy(args, i)
+		}
+
+		args[index] = _nilPlaceholder(*self.config.NilPlaceholder)
+	}
+
+	if args == nil {
+		return i
+	}
+
+	return args
+}
+
+func _isNil(arg any) bool {
+	if arg == nil {
+		return true
+	}
+
+	value := reflect.ValueOf(arg)
+
+	return (value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface) && value.IsNil()
+}
+
+func (self Localizer) translate(
+	ctx context.Context, locale language.Tag, copy string, text string) (string, bool) { // nolint
+	key := _localizerTranslation{locale: locale, copy: copy}
+
+	if translated, ok := self.translations.Load(key); ok {
+		return translated.(string), true
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *self.config.TranslatorTimeout)
+	defer cancel()
+
+	var translated string
+
+	err := Utils.Deadline(ctx, func(exceeded <-chan struct{}) error {
+		var err error
+
+		translated, err = self.config.Translator(ctx, self.config.DefaultLocale, locale, text)
+
+		return err
+	})
+	if err != nil {
+		self.observer.Warnf(ctx, "Cannot translate copy %s to locale %s: %v", copy, locale, err)
+		return "", false
+	}
+
+	self.translations.Store(key, translated)
+
+	return translated, true
+}
+
+// FormatTime formats the time with the layout of the given style (short, medium or long)
+// declared in the _formats section of the locale file, which holds Go time layouts.
+func (self Localizer) FormatTime(ctx context.Context, t time.Time, style string) string {
+	format := self.normalize(_LOCALIZER_FORMATS_SECTION + "." + style)
+
+	self.mutex.RLock()
+	layout, ok := self.find(self.GetLocale(ctx), format)
+	layoutD, okD := (*self.copies)[self.config.DefaultLocale][format]
+	self.mutex.RUnlock()
+
+	if ok {
+		return t.Format(layout)
+	}
+
+	if okD {
+		return t.Format(layoutD)
+	}
+
+	if layout, ok := _LOCALIZER_DEFAULT_TIME_FORMATS[strings.ToUpper(style)]; ok {
+		return t.Format(layout)
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+// FormatNumber formats the number with the digit grouping and decimal separator of the context locale.
+func (self Localizer) FormatNumber(ctx context.Context, n float64) string {
+	return message.NewPrinter(self.GetLocale(ctx)).Sprint(number.Decimal(n))
+}
+
+// FormatCurrency formats the amount with the symbol of the ISO 4217 currency code, such as EUR,
+// and the number format of the context locale. Unknown currency codes are appended to the number.
+func (self Localizer) FormatCurrency(ctx context.Context, amount float64, currencyCode string) string {
+	unit, err := currency.ParseISO(currencyCode)
+	if err != nil {
+		self.observer.Warnf(ctx, "Cannot format currency %s: %v", currencyCode, err)
+		return self.FormatNumber(ctx, amount) + " " + currencyCode
+	}
+
+	return message.NewPrinter(self.GetLocale(ctx)).Sprint(currency.Symbol(unit.Amount(amount)))
+}
+
+// Funcs returns the localizer template functions, which take the context as first argument.
+func (self *Localizer) Funcs() template.FuncMap {
+	return template.FuncMap{
+		"localize": func(ctx context.Context, copy string, i ...any) string { // nolint
+			return self.Localize(ctx, copy, i...)
+		},
+		"formatTime": func(ctx context.Context, t time.Time, style string) string {
+			return self.FormatTime(ctx, t, style)
+		},
+		"ordinal": func(ctx context.Context, n int) string {
+			return self.Ordinal(ctx, n)
+		},
+		"localizeHTML": func(ctx context.Context, copy string, i ...any) template.HTML { // nolint
+			return self.LocalizeHTML(ctx, copy, i...)
+		},
+	}
+}
+
+// AvailableLocales returns the loaded locales sorted, e.g. to render a language picker.
+func (self Localizer) AvailableLocales() []language.Tag {
+	self.mutex.RLock()
+	locales := make([]language.Tag, 0, len(*self.copies))
+	for locale := range *self.copies {
+		locales = append(locales, locale)
+	}
+	self.mutex.RUnlock()
+
+	sort.Slice(locales, func(i, j int) bool {
+		return locales[i].String() < locales[j].String()
+	})
+
+	return locales
+}
+
+// Negotiate returns the loaded locale that best matches the Accept-Language header,
+// or the default locale when nothing fits.
+func (self Localizer) Negotiate(header string) language.Tag {
+	tags, _, err := language.ParseAcceptLanguage(header)
+	if err != nil || len(tags) < 1 {
+		return self.config.DefaultLocale
+	}
+
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	_, index, confidence := (*self.matcher).Match(tags...)
+	if confidence == language.No {
+		return self.config.DefaultLocale
+	}
+
+	return (*self.locales)[index]
+}
+
+// VerifyManifest checks that every copy of the manifest exists at least in the default locale.
+func (self Localizer) VerifyManifest(copies []string) error {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	missing := make([]string, 0)
+
+	for _, copy := range copies { // nolint
+		copy = self.normalize(copy) // nolint
+
+		if _, ok := (*self.copies)[self.config.DefaultLocale][copy]; !ok {
+			missing = append(missing, copy)
+		}
+	}
+
+	if len(missing) > 0 {
+		return ErrLocalizerGeneric().Withf("%d copies missing in default locale %s: %s",
+			len(missing), self.config.DefaultLocale, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// reserved reports whether the copy belongs to the sections configuring the locale rather than to its copies,
+// which fall back to the builtin defaults when missing.
+func (self Localizer) reserved(copy string) bool { // nolint
+	return copy == _LOCALIZER_EXTENDS_KEY ||
+		strings.HasPrefix(copy, self.normalize(_LOCALIZER_FORMATS_SECTION+".")) ||
+		strings.HasPrefix(copy, self.normalize(_LOCALIZER_ORDINALS_SECTION+"."))
+}
+
+// Validate checks that every copy of the default locale exists in the other loaded locales, or in their
+// fallbacks, listing the missing ones by locale. Plural forms are not required when the other form exists,
+// as each language has its own forms, nor are the _formats and _ordinals sections.
+func (self Localizer) Validate() error {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	locales := make([]language.Tag, 0, len(*self.copies))
+	for locale := range *self.copies {
+		if locale != self.config.DefaultLocale {
+			locales = append(locales, locale)
+		}
+	}
+
+	sort.Slice(locales, func(i, j int) bool {
+		return locales[i].String() < locales[j].String()
+	})
+
+	copies := make([]string, 0, len((*self.copies)[self.config.DefaultLocale]))
+	for copy := range (*self.copies)[self.config.DefaultLocale] { // nolint
+		if !self.reserved(copy) {
+			copies = append(copies, copy)
+		}
+	}
+
+	sort.Strings(copies)
+
+	missing := make([]string, 0)
+
+	for _, locale := range locales {
+		missingL := make([]string, 0)
+
+		for _, copy := range copies { // nolint
+			if _, ok := self.find(locale, copy); ok {
+				continue
+			}
+
+			if other, ok := self.pluralOther(copy); ok {
+				if _, ok := self.find(locale, other); ok {
+					continue
+				}
+			}
+
+			missingL = append(missingL, copy)
+		}
+
+		if len(missingL) > 0 {
+			missing = append(missing, fmt.Sprintf("%d copies missing in locale %s: %s",
+				len(missingL), locale, strings.Join(missingL, ", ")))
+		}
+	}
+
+	if len(missing) > 0 {
+		return ErrLocalizerGeneric().With(strings.Join(missing, "; "))
+	}
+
+	return nil
+}
+
+// pluralOther returns the other form of the copy when it is a plural form.
+func (self Localizer) pluralOther(copy string) (string, bool) { // nolint
+	dot := strings.LastIndex(copy, ".")
+	if dot < 0 {
+		return "", false
+	}
+
+	for _, form := range _LOCALIZER_PLURAL_FORMS {
+		if copy[dot+1:] == self.normalize(strings.ToLower(form)) {
+			return copy[:dot+1] + self.normalize(strings.ToLower(_LOCALIZER_PLURAL_FORMS[plural.Other])), true
+		}
+	}
+
+	return "", false
+}
+
+// Ordinal formats the number with the copy of its CLDR ordinal category (one, two, few, many
+// or other) declared in the _ordinals section of the locale file, such as "%dst" for one in English.
+// Falls back to the other category and, when no ordinal copy is available, to the bare number.
+func (self Localizer) Ordinal(ctx context.Context, n int) string {
+	locale := self.GetLocale(ctx)
+
+	abs := n
+	if abs < 0 {
+		abs = -abs
+	}
+
+	form := plural.Ordinal.MatchPlural(locale, abs%_LOCALIZER_PLURAL_MAX_MOD, 0, 0, 0, 0)
+	section := _LOCALIZER_ORDINALS_SECTION + "."
+
+	self.mutex.RLock()
+	trans, ok := self.find(locale, self.normalize(section+strings.ToLower(_LOCALIZER_PLURAL_FORMS[form])))
+	transO, okO := self.find(locale, self.normalize(section+strings.ToLower(_LOCALIZER_PLURAL_FORMS[plural.Other])))
+	self.mutex.RUnlock()
+
+	if ok {
+		return fmt.Sprintf(trans, n)
+	}
+
+	if okO {
+		return fmt.Sprintf(transO, n)
+	}
+
+	return strconv.Itoa(n)
+}
+
+// PluralCategory returns the CLDR cardinal plural category (zero, one, two, few, many or other)
+// of the number in the context locale, from the CLDR plural rules embedded in x/text.
+func (self Localizer) PluralCategory(ctx context.Context, n int) string {
+	abs := n
+	if abs < 0 {
+		abs = -abs
+	}
+
+	form := plural.Cardinal.MatchPlural(self.GetLocale(ctx), abs%_LOCALIZER_PLURAL_MAX_MOD, 0, 0, 0, 0)
+
+	return strings.ToLower(_LOCALIZER_PLURAL_FORMS[form])
+}
+
+// LocalizePlural localizes the form of the copy for the CLDR cardinal category of the count (zero,
+// one, two, few, many or other), declared as a map of forms under the copy in the locale file or as
+// COPY.FORM copies. Falls back to the other form. The count is the first interpolation argument,
+// followed by the trailing arguments, so "%[2]s has %[1]d new messages" would be called with
+// LocalizePlural(ctx, "NEW_MESSAGES", count, name).
+func (self Localizer) LocalizePlural(ctx context.Context, copy string, count int, i ...any) string { // nolint
+	locale := self.GetLocale(ctx)
+
+	copy += "." // nolint
+	key := self.normalize(copy + self.PluralCategory(ctx, count))
+	keyO := self.normalize(copy + strings.ToLower(_LOCALIZER_PLURAL_FORMS[plural.Other]))
+
+	self.mutex.RLock()
+	_, ok := self.find(locale, key)
+	_, okO := self.find(locale, keyO)
+	_, okD := (*self.copies)[self.config.DefaultLocale][key]
+	self.mutex.RUnlock()
+
+	if !ok && (okO || !okD) {
+		key = keyO
+	}
+
+	return self.Localize(ctx, key, append([]any{count}, i...)...)
+}
+
+// LocalizeNamed localizes the copy substituting its {name} placeholders with the named arguments.
+// A placeholder can declare a default used when its argument is missing, like {name|default:there},
+// where a } or a \ within the default must be escaped with a backslash (| and : need no escaping).
+// Placeholders with neither argument nor default are left intact, unless EmptyMissingNamed is set.
+func (self Localizer) LocalizeNamed(ctx context.Context, copy string, args map[string]any) string { // nolint
+	copy = self.normalize(copy) // nolint
+	out := copy
+
+	if trans, ok := self.resolve(ctx, copy); ok {
+		out = _LOCALIZER_NAMED_PLACEHOLDER.ReplaceAllStringFunc(trans, func(placeholder string) string {
+			match := _LOCALIZER_NAMED_PLACEHOLDER.FindStringSubmatch(placeholder)
+
+			if arg, ok := args[match[1]]; ok {
+				if _isNil(arg) {
+					return *self.config.NilPlaceholder
+				}
+
+				return fmt.Sprint(arg)
+			}
+
+			if strings.Contains(placeholder, "|default:") {
+				return _LOCALIZER_NAMED_ESCAPE.ReplaceAllString(match[2], "$1")
+			}
+
+			if self.config.EmptyMissingNamed {
+				return ""
+			}
+
+			return placeholder
+		})
+	} else {
+		self.missing(ctx, copy)
+	}
+
+	if self.config.Transform != nil {
+		return self.config.Transform(ctx, copy, out)
+	}
+
+	return out
+}
+
+// resolve returns the unformatted copy of the context locale, or its machine translation,
+// or the one of the default locale.
+func (self Localizer) resolve(ctx context.Context, copy string) (string, bool) { // nolint
+	locale := self.GetLocale(ctx)
+
+	self.mutex.RLock()
+	trans, ok := self.find(locale, copy)
+	transD, okD := (*self.copies)[self.config.DefaultLocale][copy]
+	self.mutex.RUnlock()
+
+	self.notify(ctx, locale, copy, ok, okD)
+
+	if ok {
+		return trans, true
+	}
+
+	if okD {
+		if self.config.Translator != nil && locale != self.config.DefaultLocale {
+			if translated, ok := self.translate(ctx, locale, copy, transD); ok {
+				return translated, true
+			}
+		}
+
+		return transD, true
+	}
+
+	return "", false
+}
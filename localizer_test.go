@@ -0,0 +1,1609 @@
+package kit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+func _testLocalizer(t *testing.T, locales fstest.MapFS, config LocalizerConfig) *Localizer {
+	t.Helper()
+
+	observer, err := NewObserver(context.Background(), ObserverConfig{Level: LvlDebug}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	config.LocalesFS = locales
+
+	localizer, err := NewLocalizer(*observer, config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return localizer
+}
+
+func TestLocalizerRefreshConcurrently(t *testing.T) {
+	localizer := _testLocalizer(t, fstest.MapFS{
+		"en.yml": {Data: []byte("HELLO: Hello\n")},
+		"es.yml": {Data: []byte("HELLO: Hola\n")},
+	}, LocalizerConfig{DefaultLocale: language.English})
+
+	ctx := localizer.SetLocale(context.Background(), language.Spanish)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				if copy := localizer.Localize(ctx, "HELLO"); copy != "Hola" { // nolint
+					t.Errorf("expected Hola, got %s", copy)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 100; i++ {
+		err := localizer.Refresh()
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			break
+		}
+
+		err = localizer.RefreshLocale(language.Spanish)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			break
+		}
+	}
+
+	close(done)
+	wg.Wait()
+}
+
+func TestLocalizerStripJSONComments(t *testing.T) {
+	cases := []struct {
+		name     string
+		document string
+		expected map[string]any
+	}{
+		{
+			name:     "line comments",
+			document: "{\n  // greeting\n  \"HELLO\": \"Hello\" // trailing\n}",
+			expected: map[string]any{"HELLO": "Hello"},
+		},
+		{
+			name:     "block comments",
+			document: "/* header\n spanning lines */ {\"HELLO\": /* inline */ \"Hello\"}",
+			expected: map[string]any{"HELLO": "Hello"},
+		},
+		{
+			name:     "trailing commas",
+			document: "{\"GROUP\": {\"A\": \"a\", \"LIST\": [\"b\", ], }, }",
+			expected: map[string]any{"GROUP": map[string]any{"A": "a", "LIST": []any{"b"}}},
+		},
+		{
+			name:     "trailing comma before comment",
+			document: "{\"HELLO\": \"Hello\", // last\n /* end */ }",
+			expected: map[string]any{"HELLO": "Hello"},
+		},
+		{
+			name:     "comments within strings",
+			document: `{"URL": "https://example.com/*path*/", "ESCAPED": "say \"// hi\", ok", "COMMA": "a, }"}`,
+			expected: map[string]any{
+				"URL":     "https://example.com/*path*/",
+				"ESCAPED": `say "// hi", ok`,
+				"COMMA":   "a, }",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var actual map[string]any
+
+			err := json.Unmarshal(_stripJSONComments([]byte(c.document)), &actual)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(actual, c.expected) {
+				t.Errorf("expected %v, got %v", c.expected, actual)
+			}
+		})
+	}
+}
+
+func TestLocalizerJSONCLocales(t *testing.T) {
+	localizer := _testLocalizer(t, fstest.MapFS{
+		"en.jsonc": {Data: []byte("{\n  // greeting\n  \"HELLO\": \"Hello\",\n}")},
+		"es.json5": {Data: []byte("{\n  /* greeting */\n  \"HELLO\": \"Hola\",\n}")},
+	}, LocalizerConfig{DefaultLocale: language.English})
+
+	for locale, expected := range map[language.Tag]string{language.English: "Hello", language.Spanish: "Hola"} {
+		if copy := localizer.LocalizeIn(locale, "HELLO"); copy != expected { // nolint
+			t.Errorf("expected %s in %s, got %s", expected, locale, copy)
+		}
+	}
+}
+
+func TestLocalizerFallbacks(t *testing.T) {
+	locales := fstest.MapFS{
+		"en.yml":     {Data: []byte("AR: en\nLATAM: en\nES: en\nEN: en\n")},
+		"es.yml":     {Data: []byte("AR: es\nLATAM: es\nES: es\n")},
+		"es-419.yml": {Data: []byte("AR: es-419\nLATAM: es-419\n")},
+		"es-AR.yml":  {Data: []byte("AR: es-AR\n")},
+	}
+
+	latinAmericanSpanish := language.MustParse("es-419")
+	argentinianSpanish := language.MustParse("es-AR")
+
+	cases := []struct {
+		name      string
+		fallbacks map[language.Tag][]language.Tag
+		locale    language.Tag
+		expected  map[string]string
+	}{
+		{
+			name:     "regional parents",
+			locale:   argentinianSpanish,
+			expected: map[string]string{"AR": "es-AR", "LATAM": "es-419", "ES": "es", "EN": "en", "MISSING": "MISSING"},
+		},
+		{
+			name:     "regional parents from the middle",
+			locale:   latinAmericanSpanish,
+			expected: map[string]string{"AR": "es-419", "LATAM": "es-419", "ES": "es", "EN": "en"},
+		},
+		{
+			name:      "explicit chain",
+			fallbacks: map[language.Tag][]language.Tag{argentinianSpanish: {language.Spanish}},
+			locale:    argentinianSpanish,
+			expected:  map[string]string{"AR": "es-AR", "LATAM": "es", "ES": "es", "EN": "en"},
+		},
+		{
+			name:      "explicit empty chain",
+			fallbacks: map[language.Tag][]language.Tag{argentinianSpanish: {}},
+			locale:    argentinianSpanish,
+			expected:  map[string]string{"AR": "es-AR", "LATAM": "en", "ES": "en", "EN": "en"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			localizer := _testLocalizer(t, locales, LocalizerConfig{
+				DefaultLocale: language.English,
+				Fallbacks:     c.fallbacks,
+			})
+
+			for key, expected := range c.expected {
+				if copy := localizer.LocalizeIn(c.locale, key); copy != expected { // nolint
+					t.Errorf("expected %s to be %s, got %s", key, expected, copy)
+				}
+			}
+		})
+	}
+}
+
+func TestLocalizerNestedCopies(t *testing.T) {
+	cases := []struct {
+		name     string
+		locale   string
+		expected map[string]string
+		err      bool
+	}{
+		{
+			name:   "nested",
+			locale: "ERRORS:\n  NOT_FOUND: Not found\n  VALIDATION:\n    REQUIRED: Required\n",
+			expected: map[string]string{
+				"ERRORS.NOT_FOUND":           "Not found",
+				"ERRORS.VALIDATION.REQUIRED": "Required",
+			},
+		},
+		{
+			name:     "scalars",
+			locale:   "CODE: 404\nERRORS:\n  CODE: 404\n  RETRY: true\n  RATIO: 1.5\n",
+			expected: map[string]string{"CODE": "404", "ERRORS.CODE": "404", "ERRORS.RETRY": "true", "ERRORS.RATIO": "1.5"},
+		},
+		{
+			name:     "lowercase keys",
+			locale:   "errors:\n  not_found: Not found\n",
+			expected: map[string]string{"ERRORS.NOT_FOUND": "Not found"},
+		},
+		{name: "list", locale: "LIST:\n  - a\n", err: true},
+		{name: "nested list", locale: "ERRORS:\n  LIST:\n    - a\n", err: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			copies, err := _getLocaleCopies(fstest.MapFS{"en.yml": {Data: []byte(c.locale)}},
+				"en.yml", language.English, LocalizerKeyNormalizationUpper)
+
+			switch {
+			case c.err && err == nil:
+				t.Fatalf("expected the locale to fail to load")
+			case !c.err && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for key, expected := range c.expected {
+				if copies[key] != expected {
+					t.Errorf("expected %s to be %q, got %q", key, expected, copies[key])
+				}
+			}
+		})
+	}
+}
+
+func TestLocalizerNilPlaceholder(t *testing.T) {
+	locales := fstest.MapFS{
+		"en.yml": {Data: []byte("ORDER: Order %d of %s\nNAME: Hi %v\n")},
+	}
+
+	cases := []struct {
+		name        string
+		placeholder *string
+		copy        string
+		args        []any
+		expected    string
+	}{
+		{
+			name:     "string verb",
+			copy:     "ORDER",
+			args:     []any{42, nil},
+			expected: "Order 42 of ",
+		},
+		{
+			name:     "numeric verb",
+			copy:     "ORDER",
+			args:     []any{nil, "Alice"},
+			expected: "Order  of Alice",
+		},
+		{
+			name:        "custom placeholder",
+			placeholder: ptr("-"),
+			copy:        "ORDER",
+			args:        []any{nil, nil},
+			expected:    "Order - of -",
+		},
+		{
+			name:        "typed nil",
+			placeholder: ptr("nobody"),
+			copy:        "NAME",
+			args:        []any{(*string)(nil)},
+			expected:    "Hi nobody",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			localizer := _testLocalizer(t, locales, LocalizerConfig{
+				DefaultLocale:  language.English,
+				NilPlaceholder: c.placeholder,
+			})
+
+			if copy := localizer.Localize(context.Background(), c.copy, c.args...); copy != c.expected { // nolint
+				t.Errorf("expected %q, got %q", c.expected, copy)
+			}
+		})
+	}
+}
+
+func TestLocalizerFileLocale(t *testing.T) {
+	cases := []struct {
+		name     string
+		path     string
+		expected language.Tag
+		ok       bool
+	}{
+		{name: "file", path: "en.yml", expected: language.English, ok: true},
+		{name: "directory", path: "es/emails.yml", expected: language.Spanish, ok: true},
+		{name: "nested directory", path: "locales/es/emails.yml", expected: language.Spanish, ok: true},
+		{name: "file within a locale-like directory", path: "old/en.yml", expected: language.English, ok: true},
+		{name: "file within a locale directory", path: "es/en.yml", expected: language.English, ok: true},
+		{name: "neither", path: "emails/common.yml", ok: false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			lang, ok := _getFileLocale(c.path)
+			if ok != c.ok {
+				t.Fatalf("expected ok to be %t, got %t", c.ok, ok)
+			}
+
+			if ok && lang != c.expected {
+				t.Errorf("expected %s, got %s", c.expected, lang)
+			}
+		})
+	}
+}
+
+func TestLocalizerValidate(t *testing.T) {
+	cases := []struct {
+		name     string
+		locales  fstest.MapFS
+		expected string
+	}{
+		{
+			name: "complete",
+			locales: fstest.MapFS{
+				"en.yml": {Data: []byte("HELLO: Hello\n")},
+				"es.yml": {Data: []byte("HELLO: Hola\n")},
+			},
+		},
+		{
+			name: "missing",
+			locales: fstest.MapFS{
+				"en.yml": {Data: []byte("HELLO: Hello\nBYE: Bye\nTHANKS: Thanks\n")},
+				"es.yml": {Data: []byte("HELLO: Hola\n")},
+				"fr.yml": {Data: []byte("HELLO: Bonjour\nBYE: Au revoir\n")},
+			},
+			expected: "2 copies missing in locale es: BYE, THANKS; 1 copies missing in locale fr: THANKS",
+		},
+		{
+			name: "fallback",
+			locales: fstest.MapFS{
+				"en.yml":    {Data: []byte("HELLO: Hello\nBYE: Bye\n")},
+				"es.yml":    {Data: []byte("HELLO: Hola\nBYE: Adiós\n")},
+				"es-AR.yml": {Data: []byte("HELLO: Buenas\n")},
+			},
+		},
+		{
+			name: "plural forms",
+			locales: fstest.MapFS{
+				"en.yml": {Data: []byte("ITEMS:\n  ONE: One item\n  OTHER: Items\n")},
+				"ja.yml": {Data: []byte("ITEMS:\n  OTHER: Items\n")},
+			},
+		},
+		{
+			name: "reserved sections",
+			locales: fstest.MapFS{
+				"en.yml": {Data: []byte("HELLO: Hello\n_formats:\n  short: '01/02'\n_ordinals:\n  one: '%dst'\n  other: '%dth'\n")},
+				"es.yml": {Data: []byte("HELLO: Hola\n")},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			localizer := _testLocalizer(t, c.locales, LocalizerConfig{DefaultLocale: language.English})
+
+			err := localizer.Validate()
+
+			switch {
+			case c.expected == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case c.expected != "" && err == nil:
+				t.Fatalf("expected %q, got no error", c.expected)
+			case c.expected != "" && !strings.Contains(err.Error(), c.expected):
+				t.Errorf("expected %q, got %q", c.expected, err.Error())
+			}
+		})
+	}
+}
+
+func TestLocalizerFormatTime(t *testing.T) {
+	localizer := _testLocalizer(t, fstest.MapFS{
+		"en.yml": {Data: []byte("_formats:\n  short: '01/02/2006'\n")},
+		"es.yml": {Data: []byte("_formats:\n  short: '02/01/2006'\n  long: '2 de January de 2006'\n")},
+		"fr.yml": {Data: []byte("HELLO: Bonjour\n")},
+	}, LocalizerConfig{DefaultLocale: language.English})
+
+	moment := time.Date(2023, time.March, 7, 15, 4, 5, 0, time.UTC)
+
+	cases := []struct {
+		name     string
+		locale   language.Tag
+		style    string
+		expected string
+	}{
+		{name: "locale", locale: language.Spanish, style: "short", expected: "07/03/2023"},
+		{name: "style case", locale: language.Spanish, style: "SHORT", expected: "07/03/2023"},
+		{name: "regional fallback", locale: language.MustParse("es-AR"), style: "long", expected: "7 de March de 2023"},
+		{name: "default locale", locale: language.French, style: "short", expected: "03/07/2023"},
+		{name: "builtin", locale: language.French, style: "medium", expected: "Mar 7, 2023 15:04"},
+		{name: "unknown", locale: language.French, style: "full", expected: "2023-03-07T15:04:05Z"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := localizer.SetLocale(context.Background(), c.locale)
+
+			if actual := localizer.FormatTime(ctx, moment, c.style); actual != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, actual)
+			}
+		})
+	}
+}
+
+func TestLocalizerTranslator(t *testing.T) {
+	locales := fstest.MapFS{
+		"en.yml": {Data: []byte("HELLO: Hello %s\nBYE: Bye\n")},
+		"es.yml": {Data: []byte("BYE: Adiós\n")},
+	}
+
+	cases := []struct {
+		name     string
+		locale   language.Tag
+		copy     string
+		err      error
+		slow     bool
+		expected string
+		calls    int
+	}{
+		{name: "translated", locale: language.Spanish, copy: "HELLO", expected: "[es] Hello Alice", calls: 1},
+		{name: "not missing", locale: language.Spanish, copy: "BYE", expected: "Adiós", calls: 0},
+		{name: "default locale", locale: language.English, copy: "HELLO", expected: "Hello Alice", calls: 0},
+		{name: "failure", locale: language.Spanish, copy: "HELLO", err: errors.New("failed"), expected: "Hello Alice", calls: 2},
+		{name: "timeout", locale: language.Spanish, copy: "HELLO", slow: true, expected: "Hello Alice", calls: 2},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var calls atomic.Int32
+
+			localizer := _testLocalizer(t, locales, LocalizerConfig{
+				DefaultLocale:     language.English,
+				TranslatorTimeout: ptr(20 * time.Millisecond),
+				Translator: func(ctx context.Context, from, to language.Tag, text string) (string, error) {
+					calls.Add(1)
+
+					if c.slow {
+						time.Sleep(200 * time.Millisecond)
+					}
+
+					return fmt.Sprintf("[%s] %s", to, text), c.err
+				},
+			})
+
+			ctx := localizer.SetLocale(context.Background(), c.locale)
+
+			// Localized twice to go through the cached translations
+			for i := 0; i < 2; i++ {
+				if copy := localizer.Localize(ctx, c.copy, "Alice"); copy != c.expected { // nolint
+					t.Errorf("expected %q, got %q", c.expected, copy)
+				}
+			}
+
+			if int(calls.Load()) != c.calls {
+				t.Errorf("expected %d translator calls, got %d", c.calls, calls.Load())
+			}
+		})
+	}
+}
+
+func TestLocalizerNegotiate(t *testing.T) {
+	locales := fstest.MapFS{
+		"en.yml":    {Data: []byte("HELLO: Hello\n")},
+		"es.yml":    {Data: []byte("HELLO: Hola\n")},
+		"pt-BR.yml": {Data: []byte("HELLO: Olá\n")},
+	}
+
+	localizer := _testLocalizer(t, locales, LocalizerConfig{DefaultLocale: language.English})
+
+	cases := []struct {
+		name     string
+		header   string
+		expected language.Tag
+	}{
+		{name: "exact", header: "es", expected: language.Spanish},
+		{name: "region", header: "es-MX,es;q=0.9", expected: language.Spanish},
+		{name: "weights", header: "fr;q=0.9,pt-BR;q=0.8,en;q=0.7", expected: language.MustParse("pt-BR")},
+		{name: "no match", header: "ja", expected: language.English},
+		{name: "empty", header: "", expected: language.English},
+		{name: "malformed", header: "%%%", expected: language.English},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if actual := localizer.Negotiate(c.header); actual != c.expected {
+				t.Errorf("expected %s, got %s", c.expected, actual)
+			}
+		})
+	}
+
+	t.Run("refreshed", func(t *testing.T) {
+		locales["ja.yml"] = &fstest.MapFile{Data: []byte("HELLO: こんにちは\n")}
+		defer delete(locales, "ja.yml")
+
+		err := localizer.Refresh()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if actual := localizer.Negotiate("ja"); actual != language.Japanese {
+			t.Errorf("expected %s, got %s", language.Japanese, actual)
+		}
+	})
+}
+
+func TestLocalizerVerifyManifest(t *testing.T) {
+	localizer := _testLocalizer(t, fstest.MapFS{
+		"en.yml": {Data: []byte("HELLO: Hello\nERRORS:\n  NOT_FOUND: Not found\n")},
+		"es.yml": {Data: []byte("BYE: Adiós\n")},
+	}, LocalizerConfig{DefaultLocale: language.English})
+
+	cases := []struct {
+		name     string
+		manifest []string
+		expected string
+	}{
+		{name: "empty"},
+		{name: "found", manifest: []string{"HELLO", "errors.not_found"}},
+		{
+			name:     "missing",
+			manifest: []string{"HELLO", "BYE", "ERRORS.FORBIDDEN"},
+			expected: "2 copies missing in default locale en: BYE, ERRORS.FORBIDDEN",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := localizer.VerifyManifest(c.manifest)
+
+			switch {
+			case c.expected == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case c.expected != "" && err == nil:
+				t.Fatalf("expected %q, got no error", c.expected)
+			case c.expected != "" && !strings.Contains(err.Error(), c.expected):
+				t.Errorf("expected %q, got %q", c.expected, err.Error())
+			}
+		})
+	}
+}
+
+func TestLocalizerOrdinal(t *testing.T) {
+	localizer := _testLocalizer(t, fstest.MapFS{
+		"en.yml": {Data: []byte("_ordinals:\n  one: '%dst'\n  two: '%dnd'\n  few: '%drd'\n  other: '%dth'\n")},
+		"es.yml": {Data: []byte("_ordinals:\n  other: '%dº'\n")},
+		"fr.yml": {Data: []byte("HELLO: Bonjour\n")},
+	}, LocalizerConfig{DefaultLocale: language.English})
+
+	cases := []struct {
+		locale   language.Tag
+		n        int
+		expected string
+	}{
+		{locale: language.English, n: 1, expected: "1st"},
+		{locale: language.English, n: 2, expected: "2nd"},
+		{locale: language.English, n: 3, expected: "3rd"},
+		{locale: language.English, n: 4, expected: "4th"},
+		{locale: language.English, n: 11, expected: "11th"},
+		{locale: language.English, n: 12, expected: "12th"},
+		{locale: language.English, n: 21, expected: "21st"},
+		{locale: language.English, n: 103, expected: "103rd"},
+		{locale: language.English, n: -1, expected: "-1st"},
+		{locale: language.Spanish, n: 1, expected: "1º"},
+		{locale: language.French, n: 2, expected: "2"},
+	}
+
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%s %d", c.locale, c.n), func(t *testing.T) {
+			ctx := localizer.SetLocale(context.Background(), c.locale)
+
+			if actual := localizer.Ordinal(ctx, c.n); actual != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, actual)
+			}
+		})
+	}
+}
+
+func TestLocalizerFormatMismatch(t *testing.T) {
+	localizer := _testLocalizer(t, fstest.MapFS{
+		"en.yml": {Data: []byte("GREET: Hello %s\nCOUNT: Count %d\nBOTH: Both %d\nLITERAL: 100%% sure %s\n")},
+		"es.yml": {Data: []byte("GREET: Hola %s\nCOUNT: Cuenta %d %s\nBOTH: Ambos %d %d\nLITERAL: 100%% seguro %s\n")},
+	}, LocalizerConfig{DefaultLocale: language.English})
+
+	ctx := localizer.SetLocale(context.Background(), language.Spanish)
+
+	cases := []struct {
+		name     string
+		copy     string
+		args     []any
+		expected string
+	}{
+		{name: "matching", copy: "GREET", args: []any{"Alice"}, expected: "Hola Alice"},
+		{name: "locale mismatch", copy: "COUNT", args: []any{3}, expected: "Count 3"},
+		{name: "every mismatch", copy: "BOTH", args: []any{"three"}, expected: "Ambos %d %d"},
+		{name: "escaped percent", copy: "LITERAL", args: []any{"Alice"}, expected: "100% seguro Alice"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if copy := localizer.Localize(ctx, c.copy, c.args...); copy != c.expected { // nolint
+				t.Errorf("expected %q, got %q", c.expected, copy)
+			}
+		})
+	}
+}
+
+func TestLocalizerTransform(t *testing.T) {
+	localizer := _testLocalizer(t, fstest.MapFS{
+		"en.yml": {Data: []byte("HELLO: Hello %s\nBYE: Bye {name}\nTITLE: Welcome\n")},
+	}, LocalizerConfig{
+		DefaultLocale: language.English,
+		Transform: func(ctx context.Context, copy string, out string) string {
+			return "[" + copy + "] " + out
+		},
+	})
+
+	ctx := context.Background()
+
+	cases := []struct {
+		name     string
+		localize func() string
+		expected string
+	}{
+		{
+			name:     "localize",
+			localize: func() string { return localizer.Localize(ctx, "hello", "Alice") },
+			expected: "[HELLO] Hello Alice",
+		},
+		{
+			name:     "missing",
+			localize: func() string { return localizer.Localize(ctx, "missing") },
+			expected: "[MISSING] MISSING",
+		},
+		{
+			name:     "named",
+			localize: func() string { return localizer.LocalizeNamed(ctx, "BYE", map[string]any{"name": "Alice"}) },
+			expected: "[BYE] Bye Alice",
+		},
+		{
+			name:     "many",
+			localize: func() string { return localizer.LocalizeMany(ctx, []string{"title"})["title"] },
+			expected: "[TITLE] Welcome",
+		},
+		{
+			name:     "in",
+			localize: func() string { return localizer.LocalizeIn(language.English, "HELLO", "Bob") },
+			expected: "[HELLO] Hello Bob",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if actual := c.localize(); actual != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, actual)
+			}
+		})
+	}
+}
+
+func TestLocalizerNamedDefaults(t *testing.T) {
+	localizer := _testLocalizer(t, fstest.MapFS{
+		"en.yml": {Data: []byte(`GREET: 'Hi {name|default:there}!'
+EMPTY: 'Hi {name|default:}!'
+ESCAPED: 'Hi {name|default:a \} and a \\ b}!'
+SEPARATORS: 'Hi {name|default:a|b:c}!'
+`)},
+	}, LocalizerConfig{DefaultLocale: language.English})
+
+	cases := []struct {
+		name     string
+		copy     string
+		args     map[string]any
+		expected string
+	}{
+		{name: "argument", copy: "GREET", args: map[string]any{"name": "Alice"}, expected: "Hi Alice!"},
+		{name: "default", copy: "GREET", expected: "Hi there!"},
+		{name: "empty default", copy: "EMPTY", expected: "Hi !"},
+		{name: "escaped default", copy: "ESCAPED", expected: `Hi a } and a \ b!`},
+		{name: "separators in default", copy: "SEPARATORS", expected: "Hi a|b:c!"},
+		{name: "argument over escaped default", copy: "ESCAPED", args: map[string]any{"name": "Bob"}, expected: "Hi Bob!"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if copy := localizer.LocalizeNamed(context.Background(), c.copy, c.args); copy != c.expected { // nolint
+				t.Errorf("expected %q, got %q", c.expected, copy)
+			}
+		})
+	}
+}
+
+func TestLocalizerRefreshLocale(t *testing.T) {
+	locales := fstest.MapFS{
+		"en.yml":    {Data: []byte("HELLO: Hello\n")},
+		"es.yml":    {Data: []byte("HELLO: Hola\n")},
+		"es-MX.yml": {Data: []byte("_extends: es\nBYE: Adiós\n")},
+	}
+
+	localizer := _testLocalizer(t, locales, LocalizerConfig{DefaultLocale: language.English})
+
+	mexican := language.MustParse("es-MX")
+
+	locales["en.yml"] = &fstest.MapFile{Data: []byte("HELLO: Hi\n")}
+	locales["es.yml"] = &fstest.MapFile{Data: []byte("HELLO: Buenas\n")}
+
+	err := localizer.RefreshLocale(language.Spanish)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		locale   language.Tag
+		expected string
+	}{
+		{locale: language.Spanish, expected: "Buenas"},
+		{locale: mexican, expected: "Buenas"},
+		// The other locales are left untouched
+		{locale: language.English, expected: "Hello"},
+	}
+
+	for _, c := range cases {
+		if copy := localizer.LocalizeIn(c.locale, "HELLO"); copy != c.expected { // nolint
+			t.Errorf("expected %q in %s, got %q", c.expected, c.locale, copy)
+		}
+	}
+
+	delete(locales, "es-MX.yml")
+
+	err = localizer.RefreshLocale(mexican)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []language.Tag{language.English, language.Spanish}
+	if available := localizer.AvailableLocales(); !reflect.DeepEqual(available, expected) {
+		t.Errorf("expected %v, got %v", expected, available)
+	}
+}
+
+func TestLocalizerPluralArguments(t *testing.T) {
+	localizer := _testLocalizer(t, fstest.MapFS{
+		"en.yml": {Data: []byte(`NEW_MESSAGES:
+  one: '%[2]s has %[1]d new message'
+  other: '%[2]s has %[1]d new messages from %[3]s'
+`)},
+	}, LocalizerConfig{DefaultLocale: language.English})
+
+	cases := []struct {
+		name     string
+		count    int
+		args     []any
+		expected string
+	}{
+		{name: "one", count: 1, args: []any{"Alice"}, expected: "Alice has 1 new message"},
+		{name: "other", count: 3, args: []any{"Alice", "Bob"}, expected: "Alice has 3 new messages from Bob"},
+	}
+
+	ctx := localizer.SetLocale(context.Background(), language.English)
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if copy := localizer.LocalizePlural(ctx, "NEW_MESSAGES", c.count, c.args...); copy != c.expected { // nolint
+				t.Errorf("expected %q, got %q", c.expected, copy)
+			}
+		})
+	}
+}
+
+func TestLocalizerLocalizeIn(t *testing.T) {
+	localizer := _testLocalizer(t, fstest.MapFS{
+		"en.yml": {Data: []byte("TERMS: 'Terms of %s'\nONLY_DEFAULT: Default\n")},
+		"es.yml": {Data: []byte("TERMS: 'Términos de %s'\n")},
+	}, LocalizerConfig{DefaultLocale: language.English})
+
+	cases := []struct {
+		name     string
+		locale   language.Tag
+		copy     string
+		expected string
+	}{
+		{name: "locale", locale: language.Spanish, copy: "TERMS", expected: "Términos de Acme"},
+		{name: "default locale", locale: language.English, copy: "TERMS", expected: "Terms of Acme"},
+		{name: "default fallback", locale: language.Spanish, copy: "ONLY_DEFAULT", expected: "Default"},
+		{name: "raw key", locale: language.Spanish, copy: "MISSING", expected: "MISSING"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if copy := localizer.LocalizeIn(c.locale, c.copy, "Acme"); copy != c.expected { // nolint
+				t.Errorf("expected %q, got %q", c.expected, copy)
+			}
+		})
+	}
+}
+
+func TestLocalizerLocalizeMany(t *testing.T) {
+	localizer := _testLocalizer(t, fstest.MapFS{
+		"en.yml": {Data: []byte("HELLO: Hello\nBYE: Bye\n")},
+		"es.yml": {Data: []byte("HELLO: Hola\n")},
+	}, LocalizerConfig{DefaultLocale: language.English})
+
+	ctx := localizer.SetLocale(context.Background(), language.Spanish)
+
+	copies := localizer.LocalizeMany(ctx, []string{"HELLO", "BYE", "MISSING"})
+
+	// The copies are returned by the given copies, falling back like Localize
+	expected := map[string]string{"HELLO": "Hola", "BYE": "Bye", "MISSING": "MISSING"}
+	if !reflect.DeepEqual(copies, expected) {
+		t.Errorf("expected %v, got %v", expected, copies)
+	}
+
+	for copy, localized := range copies { // nolint
+		if single := localizer.Localize(ctx, copy); single != localized {
+			t.Errorf("expected %q as Localize, got %q", single, localized)
+		}
+	}
+}
+
+func TestLocalizerLocalizeHTML(t *testing.T) {
+	localizer := _testLocalizer(t, fstest.MapFS{
+		"en.yml": {Data: []byte("WELCOME: 'Welcome, <strong>%v</strong>!'\n")},
+	}, LocalizerConfig{DefaultLocale: language.English})
+
+	cases := []struct {
+		name     string
+		arg      any
+		expected template.HTML
+	}{
+		{name: "string", arg: "<script>", expected: "Welcome, <strong>&lt;script&gt;</strong>!"},
+		{name: "html", arg: template.HTML("<em>Alice</em>"), expected: "Welcome, <strong><em>Alice</em></strong>!"},
+		{name: "stringer", arg: language.MustParse("en-US"), expected: "Welcome, <strong>en-US</strong>!"},
+		{name: "error", arg: errors.New("a & b"), expected: "Welcome, <strong>a &amp; b</strong>!"},
+		{name: "number", arg: 42, expected: "Welcome, <strong>42</strong>!"},
+	}
+
+	ctx := localizer.SetLocale(context.Background(), language.English)
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if copy := localizer.LocalizeHTML(ctx, "WELCOME", c.arg); copy != c.expected { // nolint
+				t.Errorf("expected %q, got %q", c.expected, copy)
+			}
+		})
+	}
+
+	// The copy is embedded as is in the templates
+	tmpl := template.Must(template.New("").Parse("<p>{{ . }}</p>"))
+
+	var output strings.Builder
+
+	err := tmpl.Execute(&output, localizer.LocalizeHTML(ctx, "WELCOME", "Alice"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := "<p>Welcome, <strong>Alice</strong>!</p>"; output.String() != expected {
+		t.Errorf("expected %q, got %q", expected, output.String())
+	}
+}
+
+func TestLocalizerExport(t *testing.T) {
+	localizer := _testLocalizer(t, fstest.MapFS{
+		"en.yml": {Data: []byte("WELCOME: 'Welcome <b>%s</b>'\nAPP:\n  TITLE: Kit\nAGE: 42\n")},
+	}, LocalizerConfig{DefaultLocale: language.English})
+
+	var output strings.Builder
+
+	err := localizer.Export(language.English, &output, "json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "{\n  \"AGE\": \"42\",\n  \"APP.TITLE\": \"Kit\",\n  \"WELCOME\": \"Welcome <b>%s</b>\"\n}\n"
+	if output.String() != expected {
+		t.Errorf("expected %q, got %q", expected, output.String())
+	}
+
+	for _, format := range []string{"yaml", "json"} {
+		t.Run(format, func(t *testing.T) {
+			var output strings.Builder
+
+			err := localizer.Export(language.English, &output, format)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// The exported copies load back the same
+			exported := _testLocalizer(t, fstest.MapFS{
+				"en." + format: {Data: []byte(output.String())},
+			}, LocalizerConfig{DefaultLocale: language.English})
+
+			for _, copy := range []string{"WELCOME", "APP.TITLE", "AGE"} { // nolint
+				if got, want := exported.LocalizeIn(language.English, copy, "Alice"),
+					localizer.LocalizeIn(language.English, copy, "Alice"); got != want {
+					t.Errorf("expected %q, got %q", want, got)
+				}
+			}
+		})
+	}
+
+	err = localizer.Export(language.Spanish, &output, "json")
+	if err == nil {
+		t.Errorf("expected a locale not loaded to fail")
+	}
+
+	err = localizer.Export(language.English, &output, "xml")
+	if err == nil {
+		t.Errorf("expected an unsupported format to fail")
+	}
+}
+
+func TestLocalizerMissingKeys(t *testing.T) {
+	localizer := _testLocalizer(t, fstest.MapFS{
+		"en.yml": {Data: []byte("HELLO: Hello\nBYE: Bye\n")},
+		"es.yml": {Data: []byte("HELLO: Hola\n")},
+	}, LocalizerConfig{DefaultLocale: language.English})
+
+	ctx := localizer.WithMissingKeyCollector(localizer.SetLocale(context.Background(), language.Spanish))
+
+	localizer.Localize(ctx, "HELLO")
+	localizer.Localize(ctx, "MISSING")
+	// Found in the default locale, so it is not missing
+	localizer.Localize(ctx, "BYE")
+	localizer.LocalizeNamed(ctx, "UNKNOWN", nil)
+	localizer.Localize(ctx, "MISSING")
+
+	expected := []string{"MISSING", "UNKNOWN"}
+	if missing := localizer.MissingKeys(ctx); !reflect.DeepEqual(missing, expected) {
+		t.Errorf("expected %v, got %v", expected, missing)
+	}
+
+	if missing := localizer.MissingKeys(context.Background()); missing != nil {
+		t.Errorf("expected no missing keys without a collector, got %v", missing)
+	}
+}
+
+func TestLocalizerStrictSuggestions(t *testing.T) {
+	localizer := _testLocalizer(t, fstest.MapFS{
+		"en.yml": {Data: []byte("WELCOME_MESSAGE: Welcome\nWELCOME_TITLE: Home\nGOODBYE: Bye\nHELP: Help\nHELLO: Hello\n")},
+		"es.yml": {Data: []byte("WELCOME_BANNER: Bienvenida\n")},
+	}, LocalizerConfig{DefaultLocale: language.English})
+
+	cases := []struct {
+		name     string
+		locale   language.Tag
+		copy     string
+		expected string
+	}{
+		{
+			name:     "typo",
+			locale:   language.English,
+			copy:     "WELCOME_MESAGE",
+			expected: "did you mean WELCOME_MESSAGE, WELCOME_TITLE, HELLO?",
+		},
+		{
+			name:     "context locale",
+			locale:   language.Spanish,
+			copy:     "WELCOME_BANER",
+			expected: "did you mean WELCOME_BANNER, WELCOME_TITLE, WELCOME_MESSAGE?",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := localizer.LocalizeStrict(localizer.SetLocale(context.Background(), c.locale), c.copy)
+			if err == nil {
+				t.Fatalf("expected the missing copy to fail")
+			}
+
+			if !strings.Contains(err.Error(), c.expected) {
+				t.Errorf("expected %q in %q", c.expected, err.Error())
+			}
+		})
+	}
+}
+
+func TestLocalizerExtends(t *testing.T) {
+	localizer := _testLocalizer(t, fstest.MapFS{
+		"en.yml":    {Data: []byte("COLOR: Color\nHELLO: Hello\nBYE: Bye\n")},
+		"en-GB.yml": {Data: []byte("_extends: en\nCOLOR: Colour\n")},
+		"en-AU.yml": {Data: []byte("_extends: en-GB\nHELLO: G'day\n")},
+		"es.yml":    {Data: []byte("HELLO: Hola\n")},
+		"ca.yml":    {Data: []byte("_extends: es\nBYE: Adéu\n")},
+	}, LocalizerConfig{DefaultLocale: language.English})
+
+	cases := []struct {
+		name     string
+		locale   language.Tag
+		copy     string
+		expected string
+	}{
+		{name: "child wins", locale: language.BritishEnglish, copy: "COLOR", expected: "Colour"},
+		{name: "inherited", locale: language.BritishEnglish, copy: "HELLO", expected: "Hello"},
+		{name: "grandchild wins", locale: language.MustParse("en-AU"), copy: "HELLO", expected: "G'day"},
+		{name: "inherited from parent", locale: language.MustParse("en-AU"), copy: "COLOR", expected: "Colour"},
+		{name: "inherited from grandparent", locale: language.MustParse("en-AU"), copy: "BYE", expected: "Bye"},
+		// Not the base language, so it would fall back to the default locale instead
+		{name: "inherited from another language", locale: language.Catalan, copy: "HELLO", expected: "Hola"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if copy := localizer.LocalizeIn(c.locale, c.copy); copy != c.expected { // nolint
+				t.Errorf("expected %q, got %q", c.expected, copy)
+			}
+		})
+	}
+
+	observer, err := NewObserver(context.Background(), ObserverConfig{Level: LvlDebug}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	failing := []struct {
+		name    string
+		locales fstest.MapFS
+	}{
+		{
+			name: "cycle",
+			locales: fstest.MapFS{
+				"en.yml":    {Data: []byte("HELLO: Hello\n")},
+				"en-GB.yml": {Data: []byte("_extends: en-AU\n")},
+				"en-AU.yml": {Data: []byte("_extends: en-GB\n")},
+			},
+		},
+		{
+			name: "missing parent",
+			locales: fstest.MapFS{
+				"en.yml":    {Data: []byte("HELLO: Hello\n")},
+				"en-GB.yml": {Data: []byte("_extends: fr\n")},
+			},
+		},
+		{
+			name: "malformed parent",
+			locales: fstest.MapFS{
+				"en.yml":    {Data: []byte("HELLO: Hello\n")},
+				"en-GB.yml": {Data: []byte("_extends: not a locale\n")},
+			},
+		},
+	}
+
+	for _, c := range failing {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := NewLocalizer(*observer, LocalizerConfig{DefaultLocale: language.English, LocalesFS: c.locales})
+			if err == nil {
+				t.Errorf("expected the locales to fail loading")
+			}
+		})
+	}
+}
+
+func TestLocalizerNamed(t *testing.T) {
+	locales := fstest.MapFS{
+		"en.yml": {Data: []byte("GREET: '{greeting}, {name}! {greeting}.'\nPERCENT: '{name} is at 100%'\n")},
+		"es.yml": {Data: []byte("GREET: '¡{name}, {greeting}!'\n")},
+	}
+
+	cases := []struct {
+		name     string
+		config   LocalizerConfig
+		locale   language.Tag
+		copy     string
+		args     map[string]any
+		expected string
+	}{
+		{
+			name:     "substituted",
+			locale:   language.English,
+			copy:     "GREET",
+			args:     map[string]any{"greeting": "Hello", "name": "Alice"},
+			expected: "Hello, Alice! Hello.",
+		},
+		{
+			name:     "reordered",
+			locale:   language.Spanish,
+			copy:     "GREET",
+			args:     map[string]any{"greeting": "hola", "name": "Alice"},
+			expected: "¡Alice, hola!",
+		},
+		{
+			name:     "not printf",
+			locale:   language.English,
+			copy:     "PERCENT",
+			args:     map[string]any{"name": 42},
+			expected: "42 is at 100%",
+		},
+		{
+			name:     "missing kept",
+			locale:   language.English,
+			copy:     "GREET",
+			args:     map[string]any{"name": "Alice"},
+			expected: "{greeting}, Alice! {greeting}.",
+		},
+		{
+			name:     "missing emptied",
+			config:   LocalizerConfig{EmptyMissingNamed: true},
+			locale:   language.English,
+			copy:     "GREET",
+			args:     map[string]any{"name": "Alice"},
+			expected: ", Alice! .",
+		},
+		{
+			name:     "nil",
+			config:   LocalizerConfig{NilPlaceholder: ptr("-")},
+			locale:   language.English,
+			copy:     "GREET",
+			args:     map[string]any{"greeting": "Hi", "name": nil},
+			expected: "Hi, -! Hi.",
+		},
+		{name: "missing copy", locale: language.English, copy: "MISSING", expected: "MISSING"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.config.DefaultLocale = language.English
+			localizer := _testLocalizer(t, locales, c.config)
+
+			ctx := localizer.SetLocale(context.Background(), c.locale)
+
+			if copy := localizer.LocalizeNamed(ctx, c.copy, c.args); copy != c.expected { // nolint
+				t.Errorf("expected %q, got %q", c.expected, copy)
+			}
+		})
+	}
+}
+
+func TestLocalizerLocalesFS(t *testing.T) {
+	path := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(path, "en.yml"), []byte("HELLO: Hello from disk\n"), 0o600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	observer, err := NewObserver(context.Background(), ObserverConfig{Level: LvlDebug}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	disk, err := NewLocalizer(*observer, LocalizerConfig{DefaultLocale: language.English, LocalesPath: &path})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if copy := disk.LocalizeIn(language.English, "HELLO"); copy != "Hello from disk" { // nolint
+		t.Errorf("expected %q, got %q", "Hello from disk", copy)
+	}
+
+	locales := fstest.MapFS{
+		"en.yml":           {Data: []byte("HELLO: Hello\n")},
+		"nested/es.yml":    {Data: []byte("HELLO: Hola\n")},
+		"nested/notes.txt": {Data: []byte("HELLO: Ignored\n")},
+	}
+
+	// The FS wins over the path
+	localizer, err := NewLocalizer(*observer, LocalizerConfig{
+		DefaultLocale: language.English,
+		LocalesPath:   &path,
+		LocalesFS:     locales,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[language.Tag]string{language.English: "Hello", language.Spanish: "Hola"}
+	for locale, copy := range expected { // nolint
+		if localized := localizer.LocalizeIn(locale, "HELLO"); localized != copy {
+			t.Errorf("expected %q in %s, got %q", copy, locale, localized)
+		}
+	}
+
+	if available := localizer.AvailableLocales(); len(available) != 2 {
+		t.Errorf("expected the locales of the FS only, got %v", available)
+	}
+
+	// Refreshing reads the same FS again
+	locales["nested/es.yml"] = &fstest.MapFile{Data: []byte("HELLO: Buenas\n")}
+
+	err = localizer.Refresh()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if copy := localizer.LocalizeIn(language.Spanish, "HELLO"); copy != "Buenas" { // nolint
+		t.Errorf("expected %q, got %q", "Buenas", copy)
+	}
+}
+
+func TestLocalizerWatch(t *testing.T) {
+	path := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(path, "en.yml"), []byte("HELLO: Hello\n"), 0o600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	observer, err := NewObserver(context.Background(), ObserverConfig{Level: LvlDebug}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	localizer, err := NewLocalizer(*observer, LocalizerConfig{DefaultLocale: language.English, LocalesPath: &path})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- localizer.Watch(ctx)
+	}()
+
+	// Gives the watcher time to start watching the locales
+	time.Sleep(100 * time.Millisecond)
+
+	eventually := func(locale language.Tag, expected string) {
+		t.Helper()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for localizer.LocalizeIn(locale, "HELLO") != expected {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %q in %s, got %q", expected, locale, localizer.LocalizeIn(locale, "HELLO"))
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	err = os.WriteFile(filepath.Join(path, "en.yml"), []byte("HELLO: Hi\n"), 0o600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	eventually(language.English, "Hi")
+
+	// Directories created later are watched too
+	err = os.Mkdir(filepath.Join(path, "nested"), 0o755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	err = os.WriteFile(filepath.Join(path, "nested", "es.yml"), []byte("HELLO: Hola\n"), 0o600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	eventually(language.Spanish, "Hola")
+
+	cancel()
+
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected the watch to stop once the context is cancelled")
+	}
+}
+
+func TestLocalizerLocalizeErr(t *testing.T) {
+	locales := fstest.MapFS{
+		"en.yml": {Data: []byte("HELLO: 'Hello %s'\nBYE: Bye\n")},
+		"es.yml": {Data: []byte("HELLO: 'Hola %s'\n")},
+	}
+
+	cases := []struct {
+		name     string
+		strict   bool
+		copy     string
+		expected string
+		err      bool
+	}{
+		{name: "found", strict: true, copy: "HELLO", expected: "Hola Alice"},
+		{name: "default locale", strict: true, copy: "BYE", expected: "Bye"},
+		{name: "missing", strict: true, copy: "MISSING", err: true},
+		{name: "lenient", copy: "MISSING", expected: "MISSING"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			localizer := _testLocalizer(t, locales, LocalizerConfig{DefaultLocale: language.English, Strict: c.strict})
+
+			ctx := localizer.SetLocale(context.Background(), language.Spanish)
+
+			copy, err := localizer.LocalizeErr(ctx, c.copy, "Alice") // nolint
+			if c.err {
+				if !ErrLocalizerGeneric().Is(err) {
+					t.Errorf("expected localizer generic, got %v", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if copy != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, copy)
+			}
+		})
+	}
+}
+
+func TestLocalizerOnMissing(t *testing.T) {
+	type miss struct {
+		locale language.Tag
+		copy   string
+	}
+
+	cases := []struct {
+		name     string
+		locale   language.Tag
+		copy     string
+		expected []miss
+	}{
+		{name: "found", locale: language.Spanish, copy: "HELLO", expected: []miss{}},
+		{name: "missing in locale", locale: language.Spanish, copy: "BYE", expected: []miss{{language.Spanish, "BYE"}}},
+		{
+			name:     "missing in both",
+			locale:   language.Spanish,
+			copy:     "MISSING",
+			expected: []miss{{language.Spanish, "MISSING"}, {language.English, "MISSING"}},
+		},
+		{name: "missing in default", locale: language.English, copy: "MISSING", expected: []miss{{language.English, "MISSING"}}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			misses := []miss{}
+
+			localizer := _testLocalizer(t, fstest.MapFS{
+				"en.yml": {Data: []byte("HELLO: Hello\nBYE: Bye\n")},
+				"es.yml": {Data: []byte("HELLO: Hola\n")},
+			}, LocalizerConfig{
+				DefaultLocale: language.English,
+				OnMissing: func(ctx context.Context, locale language.Tag, copy string) {
+					misses = append(misses, miss{locale: locale, copy: copy})
+				},
+			})
+
+			localizer.Localize(localizer.SetLocale(context.Background(), c.locale), c.copy)
+
+			if !reflect.DeepEqual(misses, c.expected) {
+				t.Errorf("expected %v, got %v", c.expected, misses)
+			}
+		})
+	}
+}
+
+func TestLocalizerAvailableLocales(t *testing.T) {
+	locales := fstest.MapFS{
+		"fr.yml":    {Data: []byte("HELLO: Bonjour\n")},
+		"en.yml":    {Data: []byte("HELLO: Hello\n")},
+		"es-MX.yml": {Data: []byte("HELLO: Hola\n")},
+	}
+
+	localizer := _testLocalizer(t, locales, LocalizerConfig{DefaultLocale: language.English})
+
+	expected := []language.Tag{language.English, language.MustParse("es-MX"), language.French}
+	if available := localizer.AvailableLocales(); !reflect.DeepEqual(available, expected) {
+		t.Errorf("expected %v, got %v", expected, available)
+	}
+
+	delete(locales, "fr.yml")
+
+	err := localizer.Refresh()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected = []language.Tag{language.English, language.MustParse("es-MX")}
+	if available := localizer.AvailableLocales(); !reflect.DeepEqual(available, expected) {
+		t.Errorf("expected %v after refreshing, got %v", expected, available)
+	}
+
+	empty := _testLocalizer(t, fstest.MapFS{}, LocalizerConfig{DefaultLocale: language.English})
+	if available := empty.AvailableLocales(); available == nil || len(available) != 0 {
+		t.Errorf("expected an empty slice, got %#v", available)
+	}
+}
+
+func TestLocalizerKeyNormalization(t *testing.T) {
+	locales := fstest.MapFS{
+		"en.yml": {Data: []byte("welcomeSubject: Welcome\nApp:\n  Title: Kit\n")},
+	}
+
+	cases := []struct {
+		name          string
+		normalization *LocalizerKeyNormalization
+		expected      map[string]string
+	}{
+		{
+			name: "upper by default",
+			expected: map[string]string{
+				"welcomeSubject": "Welcome", "WELCOMESUBJECT": "Welcome", "app.title": "Kit", "APP.TITLE": "Kit",
+			},
+		},
+		{
+			name:          "lower",
+			normalization: ptr(LocalizerKeyNormalizationLower),
+			expected: map[string]string{
+				"welcomeSubject": "Welcome", "welcomesubject": "Welcome", "APP.TITLE": "Kit", "app.title": "Kit",
+			},
+		},
+		{
+			name:          "none",
+			normalization: ptr(LocalizerKeyNormalizationNone),
+			expected: map[string]string{
+				"welcomeSubject": "Welcome", "WELCOMESUBJECT": "WELCOMESUBJECT", "App.Title": "Kit", "app.title": "app.title",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			localizer := _testLocalizer(t, locales, LocalizerConfig{
+				DefaultLocale:    language.English,
+				KeyNormalization: c.normalization,
+			})
+
+			for copy, expected := range c.expected { // nolint
+				if localized := localizer.LocalizeIn(language.English, copy); localized != expected {
+					t.Errorf("expected %q for %s, got %q", expected, copy, localized)
+				}
+			}
+		})
+	}
+}
+
+func TestLocalizerFormatNumber(t *testing.T) {
+	localizer := _testLocalizer(t, fstest.MapFS{
+		"en.yml": {Data: []byte("HELLO: Hello\n")},
+	}, LocalizerConfig{DefaultLocale: language.English})
+
+	cases := []struct {
+		name   string
+		locale *language.Tag
+		number string
+		usd    string
+		eur    string
+	}{
+		{name: "default locale", number: "1,234,567.891", usd: "$ 1,234.50", eur: "€ 1,234.50"},
+		{name: "english", locale: &language.English, number: "1,234,567.891", usd: "$ 1,234.50", eur: "€ 1,234.50"},
+		{name: "spanish", locale: &language.Spanish, number: "1.234.567,891", usd: "US$ 1.234,50", eur: "€ 1.234,50"},
+		{name: "french", locale: &language.French, number: "1\u00a0234\u00a0567,891", usd: "$US 1\u00a0234,50", eur: "€ 1\u00a0234,50"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			if c.locale != nil {
+				ctx = localizer.SetLocale(ctx, *c.locale)
+			}
+
+			if number := localizer.FormatNumber(ctx, 1234567.891); number != c.number {
+				t.Errorf("expected %q, got %q", c.number, number)
+			}
+
+			if usd := localizer.FormatCurrency(ctx, 1234.5, "USD"); usd != c.usd {
+				t.Errorf("expected %q, got %q", c.usd, usd)
+			}
+
+			if eur := localizer.FormatCurrency(ctx, 1234.5, "EUR"); eur != c.eur {
+				t.Errorf("expected %q, got %q", c.eur, eur)
+			}
+
+			// Unknown currency codes are appended to the number
+			if expected, unknown := localizer.FormatNumber(ctx, 12)+" XYZW",
+				localizer.FormatCurrency(ctx, 12, "XYZW"); unknown != expected {
+				t.Errorf("expected %q, got %q", expected, unknown)
+			}
+		})
+	}
+}
+
+func TestLocalizerPluralCategories(t *testing.T) {
+	localizer := _testLocalizer(t, fstest.MapFS{
+		"en.yml": {Data: []byte("ITEMS:\n  one: '%d item'\n  other: '%d items'\nPLAIN: Plain\n")},
+		"ru.yml": {Data: []byte("ITEMS:\n  one: '%d файл'\n  few: '%d файла'\n  many: '%d файлов'\n")},
+		"ar.yml": {Data: []byte("ITEMS:\n  zero: 'لا عناصر'\n  two: 'عنصران'\n  other: '%d عنصر'\n")},
+		"es.yml": {Data: []byte("ITEMS.ONE: '%d artículo'\nITEMS.OTHER: '%d artículos'\n")},
+	}, LocalizerConfig{DefaultLocale: language.English})
+
+	arabic := language.MustParse("ar")
+
+	cases := []struct {
+		name     string
+		locale   language.Tag
+		count    int
+		category string
+		expected string
+	}{
+		{name: "english one", locale: language.English, count: 1, category: "one", expected: "1 item"},
+		{name: "english other", locale: language.English, count: 3, category: "other", expected: "3 items"},
+		{name: "english zero", locale: language.English, count: 0, category: "other", expected: "0 items"},
+		{name: "russian one", locale: language.Russian, count: 21, category: "one", expected: "21 файл"},
+		{name: "russian few", locale: language.Russian, count: 3, category: "few", expected: "3 файла"},
+		{name: "russian many", locale: language.Russian, count: 11, category: "many", expected: "11 файлов"},
+		{name: "arabic zero", locale: arabic, count: 0, category: "zero", expected: "لا عناصر"},
+		{name: "arabic two", locale: arabic, count: 2, category: "two", expected: "عنصران"},
+		// The few form is missing, so it falls back to the other form
+		{name: "arabic fallback", locale: arabic, count: 5, category: "few", expected: "5 عنصر"},
+		{name: "flattened", locale: language.Spanish, count: 1, category: "one", expected: "1 artículo"},
+		{name: "negative", locale: language.English, count: -1, category: "one", expected: "-1 item"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := localizer.SetLocale(context.Background(), c.locale)
+
+			if category := localizer.PluralCategory(ctx, c.count); category != c.category {
+				t.Errorf("expected category %q, got %q", c.category, category)
+			}
+
+			if copy := localizer.LocalizePlural(ctx, "ITEMS", c.count); copy != c.expected { // nolint
+				t.Errorf("expected %q, got %q", c.expected, copy)
+			}
+		})
+	}
+
+	// Plain copies keep localizing as before
+	if copy := localizer.Localize(context.Background(), "PLAIN"); copy != "Plain" { // nolint
+		t.Errorf("expected %q, got %q", "Plain", copy)
+	}
+}
+
+func TestLocalizerPluralCategory(t *testing.T) {
+	localizer := _testLocalizer(t, fstest.MapFS{
+		"en.yml": {Data: []byte("HELLO: Hello\n")},
+	}, LocalizerConfig{DefaultLocale: language.English})
+
+	cases := []struct {
+		locale   string
+		expected map[int]string
+	}{
+		{locale: "en", expected: map[int]string{0: "other", 1: "one", 2: "other", 101: "other"}},
+		{locale: "fr", expected: map[int]string{0: "one", 1: "one", 2: "other"}},
+		{
+			locale:   "ru",
+			expected: map[int]string{1: "one", 2: "few", 5: "many", 11: "many", 21: "one", 22: "few", 111: "many"},
+		},
+		{
+			locale:   "ar",
+			expected: map[int]string{0: "zero", 1: "one", 2: "two", 3: "few", 11: "many", 100: "other", 103: "few"},
+		},
+		{locale: "ja", expected: map[int]string{0: "other", 1: "other", 2: "other"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.locale, func(t *testing.T) {
+			ctx := localizer.SetLocale(context.Background(), language.MustParse(c.locale))
+
+			for n, expected := range c.expected {
+				if category := localizer.PluralCategory(ctx, n); category != expected {
+					t.Errorf("expected %q for %d, got %q", expected, n, category)
+				}
+			}
+		})
+	}
+
+	// The default locale is used without a context locale
+	if category := localizer.PluralCategory(context.Background(), 1); category != "one" {
+		t.Errorf("expected %q, got %q", "one", category)
+	}
+}
@@ -1,19 +1,70 @@
 package kit
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/cockroachdb/errors"
+	"github.com/eapache/go-resiliency/retrier"
+	gomysql "github.com/go-sql-driver/mysql"
 	"github.com/golang-migrate/migrate/v4"
-	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/database/multistmt"
+	_ "github.com/golang-migrate/migrate/v4/database/mysql"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/lib/pq"
 )
 
-const _MIGRATOR_POSTGRES_DSN = "postgresql://%s:%s@%s:%d/%s?sslmode=%s&x-multi-statement=true"
+const (
+	_MIGRATOR_POSTGRES_SCHEME          = "postgresql"
+	_MIGRATOR_MYSQL_DSN                = "mysql://%s:%s@tcp(%s:%d)/%s"
+	_MIGRATOR_MYSQL_MULTI_STATEMENT    = "multiStatements"
+	_MIGRATOR_MYSQL_TLS_PARAM          = "tls"
+	_MIGRATOR_SOURCE_NAME              = "file"
+	_MIGRATOR_FS_SOURCE_NAME           = "iofs"
+	_MIGRATOR_MULTI_STATEMENT_PARAM    = "x-multi-statement"
+	_MIGRATOR_TABLE_PARAM              = "x-migrations-table"
+	_MIGRATOR_APPLICATION_NAME_PARAM   = "application_name"
+	_MIGRATOR_DEFAULT_APPLICATION_NAME = "kit-migrator/%s"
+	_MIGRATOR_TIMEOUT_PARAM            = "x-statement-timeout"
+	_MIGRATOR_HISTORY_TABLE            = "kit_migrations_history"
+	_MIGRATOR_HISTORY_TABLE_SUFFIX     = "_history"
+	_MIGRATOR_HISTORY_TABLE_DDL        = `CREATE TABLE IF NOT EXISTS %s (
+		version    BIGINT      NOT NULL,
+		direction  TEXT        NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`
+	_MIGRATOR_VERSION_TABLE_DDL = `CREATE TABLE IF NOT EXISTS %s (
+		version BIGINT  NOT NULL PRIMARY KEY,
+		dirty   BOOLEAN NOT NULL
+	)`
+	_MIGRATOR_DIRECTION_UP   = "up"
+	_MIGRATOR_DIRECTION_DOWN = "down"
+	_MIGRATOR_LOCK_QUERY     = "SELECT pg_advisory_lock(%d)"
+	_MIGRATOR_UNLOCK_QUERY   = "SELECT pg_advisory_unlock(%d)"
+	_MIGRATOR_LOCK_TIMEOUT   = "SET lock_timeout = %d"
+	_MIGRATOR_LOCK_RESET     = "RESET lock_timeout"
+)
 
 var (
 	_MIGRATOR_DEFAULT_MIGRATIONS_PATH     = "./migrations"
@@ -21,12 +72,44 @@ var (
 	_MIGRATOR_DEFAULT_RETRY_INITIAL_DELAY = 0 * time.Second
 	_MIGRATOR_DEFAULT_RETRY_LIMIT_DELAY   = 0 * time.Second
 	_MIGRATOR_ERR_DB_ALREADY_CLOSED       = regexp.MustCompile(`.*connection is already closed.*`)
+	_MIGRATOR_ERR_TRANSIENT               = regexp.MustCompile(
+		`(?i)connection refused|connection reset|no route to host|network is unreachable|i/o timeout|` +
+			`starting up|shutting down|bad connection`)
+	_MIGRATOR_STATEMENT_DELIMITER         = []byte(";")
+	_MIGRATOR_CLOSEST_MATCHES             = 3
+	_MIGRATOR_NO_TRANSACTION_DIRECTIVE    = []byte("x-no-transaction")
+	_MIGRATOR_DIRECTIVE                   = regexp.MustCompile(`^--\s*kit:(\S+)`)
+	_MIGRATOR_DEFAULT_RUNNING_WARN_PERIOD = 30 * time.Second
+	_MIGRATOR_DEFAULT_VERSION_DIGITS      = 5
+	_MIGRATOR_SLUG_SEPARATORS             = regexp.MustCompile(`[^a-z0-9]+`)
+	_MIGRATOR_LOCK_RETRY_ATTEMPTS         = 1000
+	_MIGRATOR_LOCK_RETRY_INITIAL_DELAY    = 1 * time.Second
+	_MIGRATOR_LOCK_RETRY_LIMIT_DELAY      = 30 * time.Second
+	_MIGRATOR_MYSQL_TLS_MODES             = map[string]string{
+		"disable":     "false",
+		"require":     "skip-verify",
+		"verify-ca":   "true",
+		"verify-full": "true",
+	}
+)
+
+type MigratorDriver string
+
+// Builtin database drivers.
+var (
+	MigratorDriverPostgres MigratorDriver = "postgres"
+	MigratorDriverMySQL    MigratorDriver = "mysql"
 )
 
 type MigratorRetryConfig struct {
 	Attempts     int
 	InitialDelay time.Duration
 	LimitDelay   time.Duration
+	// Sleep waits between the attempts instead of time.Sleep when set, e.g. to test the backoff.
+	Sleep func(time.Duration)
+	// Retryable reports whether a connection attempt error is worth retrying, which by default are
+	// only the transient connection failures and not e.g. the authentication ones.
+	Retryable func(err error) bool
 }
 
 type MigratorConfig struct {
@@ -37,23 +120,119 @@ type MigratorConfig struct {
 	DatabaseUser     string
 	DatabasePassword string
 	DatabaseName     string
+	// DatabaseDriver is the database the migrations are applied to, postgres by default.
+	// History, AtomicRange, Dialer, Reset and the timeout and transaction migration
+	// directives are only supported with postgres.
+	DatabaseDriver *MigratorDriver
+	// ValidateOnInit validates the migration files at construction (see ValidateMigrations).
+	ValidateOnInit bool
+	// MigrationsFS is the source of the migrations instead of MigrationsPath when set,
+	// e.g. an embed.FS of the migrations bundled in the binary.
+	MigrationsFS fs.FS
+	// Verbose logs, at debug level, every statement of a migration right before executing it.
+	Verbose bool
+	// AtomicRange makes ApplyRange run all the migrations of the range in a single transaction.
+	AtomicRange bool
+	// DatabaseParams are appended to the DSN, such as connect_timeout or sslrootcert, where
+	// the parameters set by the other fields take precedence.
+	DatabaseParams map[string]string
+	// DSN is used verbatim instead of the structured database fields when set, for connection
+	// strings they cannot express, such as unix socket or connection proxy ones.
+	DSN *string
+	// History records every migration applied or rollbacked by the migrator in a history table,
+	// kit_migrations_history, or the MigrationsTable one suffixed with _history when set.
+	History bool
+	// ApplicationName identifies the migrator connections in the database server, such as in
+	// pg_stat_activity, kit-migrator/<DatabaseName> when empty.
+	ApplicationName string
+	// Dialer opens the database connections instead of the default dialer when set, e.g. to
+	// connect through an SSH tunnel to a bastion with the DialContext of an x/crypto/ssh client.
+	// Connections are made with the lib/pq driver, to which the dialer is given as its pq.Dialer.
+	Dialer func(ctx context.Context, network string, address string) (net.Conn, error)
+	// MinServerVersion, such as "14" or "14.2", is checked against the database server version
+	// at connect time when set, so older servers are rejected before running any migration.
+	MinServerVersion *string
+	// InterStepDelay makes Apply apply the migrations one at a time pausing between them when set,
+	// e.g. to give time to the replicas to catch up.
+	InterStepDelay time.Duration
+	// PostApplyChecks are run after Apply applies migrations, which fails if any of them fails.
+	PostApplyChecks []MigratorCheck
+	// AllowDestructive must be explicitly enabled to allow the operations that drop data, Reset and Drop.
+	AllowDestructive bool
+	// WaitForLock makes the migrator queue behind the ones holding the migration lock, retrying to
+	// acquire it until the duration elapses, instead of failing at the context deadline.
+	WaitForLock time.Duration
+	// StatementTimeout aborts every statement running for longer when set, regardless of the
+	// context deadline, which only bounds the wait for the migration lock (see lockTimeout).
+	StatementTimeout *time.Duration
+	// MigrationsTable is the table tracking the schema version instead of schema_migrations when set,
+	// e.g. for several apps sharing the same database.
+	MigrationsTable *string
+	// BeforeEach is called right before running each migration of Apply, Rollback and Steps with the
+	// schema version it migrates to, which aborts the run, leaving the schema version clean, on error.
+	BeforeEach func(ctx context.Context, version uint) error
+	// AfterEach is called right after running each migration with its error, if any.
+	AfterEach func(ctx context.Context, version uint, err error)
+	// RunningWarnPeriod is how often a warning is logged while Apply or Rollback keep running.
+	RunningWarnPeriod *time.Duration
+	// AdvisoryLockID is the postgres advisory lock key of the migration lock, an int64, instead of
+	// the one derived from the database and migrations table when set, e.g. for several apps
+	// sharing the same database to not contend on the same lock.
+	AdvisoryLockID *string
+	// Transactional runs every postgres migration in a transaction, unless opted out with the
+	// kit:no-transaction directive, instead of only the ones opted in with kit:transaction.
+	Transactional bool
+}
+
+type MigratorCheck struct {
+	Name  string
+	Query string
+	// Expect asserts the rows of the query, which only has to succeed when nil.
+	Expect func(rows *sql.Rows) error
+}
+
+type MigratorStatus struct {
+	Current uint
+	Dirty   bool
+	Pending []uint
+	Latest  uint
+}
+
+type MigratorHistoryEntry struct {
+	Version   uint
+	Direction string
+	AppliedAt time.Time
 }
 
 type Migrator struct {
 	config   MigratorConfig
 	observer Observer
 	migrator *migrate.Migrate
+	driver   *_migrateDriver
+	source   source.Driver
+	db       *sql.DB
+	borrowed bool
 	done     chan struct{}
 }
 
-func NewMigrator(ctx context.Context, observer Observer, config MigratorConfig,
-	retry *MigratorRetryConfig) (*Migrator, error) {
+// _prepareMigratorConfig defaults and validates the configs shared by the constructors,
+// copying the retry config so the one of the caller is left untouched.
+func _prepareMigratorConfig(
+	config MigratorConfig, retry *MigratorRetryConfig) (MigratorConfig, *MigratorRetryConfig, error) {
 	if config.MigrationsPath == nil {
 		config.MigrationsPath = ptr(_MIGRATOR_DEFAULT_MIGRATIONS_PATH)
 	}
 
 	*config.MigrationsPath = fmt.Sprintf("file://%s", filepath.Clean(*config.MigrationsPath))
 
+	if config.RunningWarnPeriod == nil {
+		config.RunningWarnPeriod = ptr(_MIGRATOR_DEFAULT_RUNNING_WARN_PERIOD)
+	}
+
+	if config.DatabaseDriver == nil {
+		config.DatabaseDriver = ptr(MigratorDriverPostgres)
+	}
+
 	if retry == nil {
 		retry = &MigratorRetryConfig{
 			Attempts:     _MIGRATOR_DEFAULT_RETRY_ATTEMPTS,
@@ -62,33 +241,214 @@ func NewMigrator(ctx context.Context, observer Observer, config MigratorConfig,
 		}
 	}
 
-	dsn := fmt.Sprintf(
-		_MIGRATOR_POSTGRES_DSN,
-		config.DatabaseUser,
-		config.DatabasePassword,
-		config.DatabaseHost,
-		config.DatabasePort,
-		config.DatabaseName,
-		config.DatabaseSSLMode,
-	)
+	retryCopy := *retry
+	retry = &retryCopy
+
+	if retry.Retryable == nil {
+		retry.Retryable = _isMigratorRetryable
+	}
+
+	if config.AdvisoryLockID != nil {
+		_, err := strconv.ParseInt(*config.AdvisoryLockID, 10, 64)
+		if err != nil {
+			return config, nil, ErrMigratorGeneric().Withf(
+				"advisory lock id %s is not an int64", *config.AdvisoryLockID)
+		}
+	}
+
+	if config.ValidateOnInit {
+		err := _validateMigrations(_migrationsFS(config))
+		if err != nil {
+			return config, nil, ErrMigratorGeneric().Wrap(err)
+		}
+	}
+
+	return config, retry, nil
+}
+
+func NewMigrator(ctx context.Context, observer Observer, config MigratorConfig,
+	retry *MigratorRetryConfig) (*Migrator, error) {
+	config, retry, err := _prepareMigratorConfig(config, retry)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.ApplicationName == "" {
+		config.ApplicationName = fmt.Sprintf(_MIGRATOR_DEFAULT_APPLICATION_NAME, config.DatabaseName)
+	}
+
+	if config.MigrationsFS != nil {
+		src, err := iofs.New(config.MigrationsFS, ".")
+		if err != nil {
+			return nil, ErrMigratorGeneric().Wrap(err)
+		}
+
+		_, err = src.First()
+		errC := src.Close()
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			return nil, ErrMigratorGeneric().With("no migrations found in the migrations fs")
+		case err != nil:
+			return nil, ErrMigratorGeneric().Wrap(err)
+		case errC != nil:
+			return nil, ErrMigratorGeneric().Wrap(errC)
+		}
+	}
+
+	dsn, err := _migratorDSN(config)
+	if err != nil {
+		return nil, err
+	}
 
 	var migrator *migrate.Migrate
+	var driver *_migrateDriver
+	var source source.Driver
+	var db *sql.DB
 
-	// TODO: only retry on specific errors
-	err := Utils.Deadline(ctx, func(exceeded <-chan struct{}) error {
-		return Utils.ExponentialRetry(
+	err = Utils.Deadline(ctx, func(exceeded <-chan struct{}) error {
+		return Utils.WithSleep(retry.Sleep).ExponentialRetry(
 			retry.Attempts, retry.InitialDelay, retry.LimitDelay,
-			nil, func(attempt int) error {
-				var err error
+			_migratorRetryClassifier(retry.Retryable), func(attempt int) error {
+				observer.Infof(ctx, "Trying to connect to the %s database %d/%d",
+					config.DatabaseName, attempt, retry.Attempts)
+
+				src, sourceName, err := _openMigrateSource(config)
+				if err != nil {
+					return ErrMigratorGeneric().WrapAs(err)
+				}
+
+				instance, err := _openMigrateDatabase(dsn, config.Dialer)
+				if err != nil {
+					return ErrMigratorGeneric().WrapAs(Utils.CombineErrors(err, src.Close()))
+				}
+
+				driver = _newMigrateDriver(&observer, instance, config)
+
+				migrator, err = migrate.NewWithInstance(sourceName, src, config.DatabaseName, driver)
+				if err != nil {
+					err = Utils.CombineErrors(err, Utils.CombineErrors(src.Close(), instance.Close()))
+					return ErrMigratorGeneric().WrapAs(err)
+				}
+
+				// The golang-migrate custom parameters are not understood by the database
+				db, err = _openMigrateDB(*config.DatabaseDriver, dsn, config.Dialer)
+				if err != nil {
+					err = Utils.CombineErrors(err, Utils.CombineErrors(src.Close(), instance.Close()))
+					return ErrMigratorGeneric().WrapAs(err)
+				}
+
+				source = src
 
+				return nil
+			})
+	})
+	switch {
+	case err == nil:
+	case ErrDeadlineExceeded().Is(err):
+		return nil, ErrMigratorTimedOut()
+	default:
+		return nil, ErrMigratorGeneric().Wrap(err)
+	}
+
+	observer.Infof(ctx, "Connected to the %s database", config.DatabaseName)
+
+	migrator.Log = _newMigrateLogger(&observer, config.Verbose)
+
+	done := make(chan struct{}, 1)
+	close(done)
+
+	instance := &Migrator{
+		observer: observer,
+		config:   config,
+		migrator: migrator,
+		driver:   driver,
+		source:   source,
+		db:       db,
+		done:     done,
+	}
+
+	err = instance.init(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return instance, nil
+}
+
+// NewMigratorWithDB builds the migrator over the given postgres database pool instead of connecting
+// with the database fields, DSN and Dialer, which are ignored. The pool is owned by the caller,
+// so it is not closed by Close.
+func NewMigratorWithDB(ctx context.Context, observer Observer, db *sql.DB, config MigratorConfig,
+	retry *MigratorRetryConfig) (*Migrator, error) {
+	config, retry, err := _prepareMigratorConfig(config, retry)
+	if err != nil {
+		return nil, err
+	}
+
+	if *config.DatabaseDriver != MigratorDriverPostgres {
+		return nil, ErrMigratorGeneric().With("an existing database is only supported with postgres")
+	}
+
+	postgresConfig := &postgres.Config{
+		MultiStatementEnabled: true,
+	}
+
+	if config.MigrationsTable != nil {
+		postgresConfig.MigrationsTable = *config.MigrationsTable
+	}
+
+	if config.StatementTimeout != nil {
+		postgresConfig.StatementTimeout = *config.StatementTimeout
+	}
+
+	var migrator *migrate.Migrate
+	var driver *_migrateDriver
+	var source source.Driver
+
+	err = Utils.Deadline(ctx, func(exceeded <-chan struct{}) error {
+		return Utils.WithSleep(retry.Sleep).ExponentialRetry(
+			retry.Attempts, retry.InitialDelay, retry.LimitDelay,
+			_migratorRetryClassifier(retry.Retryable), func(attempt int) error {
 				observer.Infof(ctx, "Trying to connect to the %s database %d/%d",
 					config.DatabaseName, attempt, retry.Attempts)
 
-				migrator, err = migrate.New(*config.MigrationsPath, dsn)
+				src, sourceName, err := _openMigrateSource(config)
+				if err != nil {
+					return ErrMigratorGeneric().WrapAs(err)
+				}
+
+				// Contrary to postgres.WithInstance, the driver of a single connection does not close the pool
+				conn, err := db.Conn(ctx)
+				if err != nil {
+					return ErrMigratorGeneric().WrapAs(Utils.CombineErrors(err, src.Close()))
+				}
+
+				// Same database name as postgres.Open, as it is part of the advisory lock ID
+				var current string
+				err = conn.QueryRowContext(ctx, "SELECT CURRENT_DATABASE()").Scan(&current)
 				if err != nil {
+					err = Utils.CombineErrors(err, Utils.CombineErrors(src.Close(), conn.Close()))
 					return ErrMigratorGeneric().WrapAs(err)
 				}
 
+				postgresConfig.DatabaseName = _migratorLockDatabaseName(current)
+
+				instance, err := postgres.WithConnection(ctx, conn, postgresConfig)
+				if err != nil {
+					err = Utils.CombineErrors(err, Utils.CombineErrors(src.Close(), conn.Close()))
+					return ErrMigratorGeneric().WrapAs(err)
+				}
+
+				driver = _newMigrateDriver(&observer, instance, config)
+
+				migrator, err = migrate.NewWithInstance(sourceName, src, config.DatabaseName, driver)
+				if err != nil {
+					err = Utils.CombineErrors(err, Utils.CombineErrors(src.Close(), instance.Close()))
+					return ErrMigratorGeneric().WrapAs(err)
+				}
+
+				source = src
+
 				return nil
 			})
 	})
@@ -102,26 +462,93 @@ func NewMigrator(ctx context.Context, observer Observer, config MigratorConfig,
 
 	observer.Infof(ctx, "Connected to the %s database", config.DatabaseName)
 
-	migrator.Log = _newMigrateLogger(&observer)
+	migrator.Log = _newMigrateLogger(&observer, config.Verbose)
 
 	done := make(chan struct{}, 1)
 	close(done)
 
-	return &Migrator{
+	instance := &Migrator{
 		observer: observer,
 		config:   config,
 		migrator: migrator,
+		driver:   driver,
+		source:   source,
+		db:       db,
+		borrowed: true,
 		done:     done,
-	}, nil
+	}
+
+	err = instance.init(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return instance, nil
+}
+
+// init checks the database server version and creates the history table when enabled.
+func (self *Migrator) init(ctx context.Context) error {
+	if self.config.MinServerVersion != nil {
+		err := Utils.Deadline(ctx, func(exceeded <-chan struct{}) error {
+			var serverVersion string
+
+			query := "SHOW server_version"
+			if *self.config.DatabaseDriver == MigratorDriverMySQL {
+				query = "SELECT VERSION()"
+			}
+
+			err := self.db.QueryRowContext(ctx, query).Scan(&serverVersion)
+			if err != nil {
+				return ErrMigratorGeneric().WrapAs(err)
+			}
+
+			older, err := _isOlderVersion(serverVersion, *self.config.MinServerVersion)
+			if err != nil {
+				return ErrMigratorGeneric().WrapAs(err)
+			}
+
+			if older {
+				return ErrMigratorGeneric().Withf("database server version %s is older than the required %s",
+					serverVersion, *self.config.MinServerVersion)
+			}
+
+			return nil
+		})
+		switch {
+		case err == nil:
+		case ErrDeadlineExceeded().Is(err):
+			return ErrMigratorTimedOut()
+		default:
+			return ErrMigratorGeneric().Wrap(err)
+		}
+	}
+
+	if self.config.History {
+		err := Utils.Deadline(ctx, func(exceeded <-chan struct{}) error {
+			_, err := self.db.ExecContext(ctx, fmt.Sprintf(_MIGRATOR_HISTORY_TABLE_DDL, _migratorHistoryTable(self.config)))
+			if err != nil {
+				return ErrMigratorGeneric().WrapAs(err)
+			}
+
+			return nil
+		})
+		switch {
+		case err == nil:
+		case ErrDeadlineExceeded().Is(err):
+			return ErrMigratorTimedOut()
+		default:
+			return ErrMigratorGeneric().Wrap(err)
+		}
+	}
+
+	return nil
 }
 
 // TODO: concurrent-safe
 func (self *Migrator) Assert(ctx context.Context, schemaVersion int) error {
 	self.done = make(chan struct{}, 1)
 
-	if ctxDeadline, ok := ctx.Deadline(); ok {
-		self.migrator.LockTimeout = time.Until(ctxDeadline)
-	}
+	self.migrator.LockTimeout = self.lockTimeout(ctx)
 
 	err := Utils.Deadline(ctx, func(exceeded <-chan struct{}) error {
 		err := func() error {
@@ -168,13 +595,14 @@ func (self *Migrator) Assert(ctx context.Context, schemaVersion int) error {
 	}
 }
 
+// Apply applies up to the desired schema version. When the context is cancelled or its deadline
+// is exceeded, the running migration still finishes, as it cannot be interrupted mid-statement,
+// but no further migrations are started.
 // TODO: concurrent-safe
 func (self *Migrator) Apply(ctx context.Context, schemaVersion int) error {
 	self.done = make(chan struct{}, 1)
 
-	if ctxDeadline, ok := ctx.Deadline(); ok {
-		self.migrator.LockTimeout = time.Until(ctxDeadline)
-	}
+	self.migrator.LockTimeout = self.lockTimeout(ctx)
 
 	err := Utils.Deadline(ctx, func(exceeded <-chan struct{}) error {
 		err := func() error {
@@ -199,12 +627,15 @@ func (self *Migrator) Apply(ctx context.Context, schemaVersion int) error {
 
 			self.observer.Infof(ctx, "%d migrations to be applied", schemaVersion-int(currentSchemaVersion))
 
-			err = self.migrator.Migrate(uint(schemaVersion))
+			start := time.Now()
+
+			err = self.apply(ctx, currentSchemaVersion, uint(schemaVersion))
 			if err != nil {
-				return ErrMigratorGeneric().WrapAs(err)
+				return err
 			}
 
-			self.observer.Info(ctx, "Applied all migrations successfully")
+			self.observer.Infof(ctx, "Applied all migrations successfully in %s",
+				time.Since(start).Round(time.Millisecond))
 
 			return nil
 		}()
@@ -230,18 +661,150 @@ func (self *Migrator) Apply(ctx context.Context, schemaVersion int) error {
 	}
 }
 
+// ApplyRange applies up to the desired schema version like Apply but, when AtomicRange is enabled,
+// runs all the pending migrations in a single transaction, so any failure rolls back all of them.
+// The range is then a single migration to the desired schema version for the BeforeEach and AfterEach
+// hooks and the context cancellation, and it is not stepped with the InterStepDelay.
+// This requires every migration in the range to be transaction-safe: none can use x-no-transaction
+// nor contain statements that cannot run inside a transaction block (e.g. CREATE INDEX CONCURRENTLY).
 // TODO: concurrent-safe
-func (self *Migrator) Rollback(ctx context.Context, schemaVersion int) error {
+func (self *Migrator) ApplyRange(ctx context.Context, schemaVersion int) error {
+	if !self.config.AtomicRange {
+		return self.Apply(ctx, schemaVersion)
+	}
+
 	self.done = make(chan struct{}, 1)
 
-	if ctxDeadline, ok := ctx.Deadline(); ok {
-		self.migrator.LockTimeout = time.Until(ctxDeadline)
+	self.migrator.LockTimeout = self.lockTimeout(ctx)
+
+	err := Utils.Deadline(ctx, func(exceeded <-chan struct{}) error {
+		err := func() (err error) {
+			err = self.driver.pin(ctx, self.migrator.LockTimeout)
+			if err != nil {
+				return ErrMigratorGeneric().WrapAs(err)
+			}
+
+			defer func() {
+				errU := self.driver.unpin()
+				if errU != nil {
+					err = ErrMigratorGeneric().WrapAs(Utils.CombineErrors(err, errU))
+				}
+			}()
+
+			previousVersion := database.NilVersion
+
+			currentSchemaVersion, bad, err := self.migrator.Version() // nolint
+			switch {
+			case err == nil:
+				previousVersion = int(currentSchemaVersion)
+			case err != migrate.ErrNilVersion:
+				return ErrMigratorGeneric().WrapAs(err)
+			}
+
+			if bad {
+				return ErrMigratorGeneric().Withf("current schema version %d is dirty", currentSchemaVersion)
+			}
+
+			if currentSchemaVersion == uint(schemaVersion) {
+				self.observer.Info(ctx, "No migrations to apply")
+				return nil
+			}
+
+			if currentSchemaVersion > uint(schemaVersion) {
+				return ErrMigratorGeneric().Withf("desired schema version %d behind from current one %d",
+					schemaVersion, currentSchemaVersion)
+			}
+
+			migrations, err := self.migrations()
+			if err != nil {
+				return ErrMigratorGeneric().Wrap(err)
+			}
+
+			bodies := make([][]byte, 0, len(migrations))
+			lastVersion := currentSchemaVersion
+
+			for _, migration := range migrations {
+				if migration.Version <= currentSchemaVersion || migration.Version > uint(schemaVersion) {
+					continue
+				}
+
+				reader, _, err := self.source.ReadUp(migration.Version)
+				if err != nil {
+					return ErrMigratorGeneric().WrapAs(err)
+				}
+
+				body, err := io.ReadAll(reader)
+				if err != nil {
+					return ErrMigratorGeneric().WrapAs(Utils.CombineErrors(err, reader.Close()))
+				}
+
+				err = reader.Close()
+				if err != nil {
+					return ErrMigratorGeneric().WrapAs(err)
+				}
+
+				if self.driver.directives(body).noTransaction {
+					return ErrMigratorGeneric().Withf("migration %s cannot be applied atomically", migration.Raw)
+				}
+
+				bodies = append(bodies, body)
+				lastVersion = migration.Version
+			}
+
+			if lastVersion != uint(schemaVersion) {
+				return ErrMigratorGeneric().Withf("desired schema version %d not found", schemaVersion)
+			}
+
+			self.observer.Infof(ctx, "%d migrations to be applied atomically", len(bodies))
+
+			unwatch := self.driver.watch(ctx)
+			err = self.driver.runAtomically(previousVersion, schemaVersion, bodies)
+			unwatch()
+			if err != nil {
+				return ErrMigratorGeneric().WrapAs(err)
+			}
+
+			self.observer.Info(ctx, "Applied all migrations atomically successfully")
+
+			self.record(ctx, currentSchemaVersion, uint(schemaVersion))
+
+			return self.check(ctx)
+		}()
+
+		select {
+		case <-self.done:
+		default:
+			close(self.done)
+		}
+
+		return err
+	})
+
+	self.migrator.LockTimeout = migrate.DefaultLockTimeout
+
+	switch {
+	case err == nil:
+		return nil
+	case ErrDeadlineExceeded().Is(err):
+		return ErrMigratorTimedOut()
+	default:
+		return ErrMigratorGeneric().Wrap(err)
 	}
+}
+
+// Rollback rollbacks down to the desired schema version. When the context is cancelled or its
+// deadline is exceeded, the running migration still finishes, as it cannot be interrupted
+// mid-statement, but no further migrations are started.
+// TODO: concurrent-safe
+func (self *Migrator) Rollback(ctx context.Context, schemaVersion int) error {
+	self.done = make(chan struct{}, 1)
+
+	self.migrator.LockTimeout = self.lockTimeout(ctx)
 
 	err := Utils.Deadline(ctx, func(exceeded <-chan struct{}) error {
 		err := func() error {
 			currentSchemaVersion, bad, err := self.migrator.Version() // nolint
-			if err != nil {
+			if err != nil && err != migrate.ErrNilVersion {
 				return ErrMigratorGeneric().WrapAs(err)
 			}
 
@@ -266,12 +829,18 @@ func (self *Migrator) Rollback(ctx context.Context, schemaVersion int) error {
 
 			self.observer.Infof(ctx, "%d migrations to be rollbacked", int(currentSchemaVersion)-schemaVersion)
 
+			start := time.Now()
+			unwatch := self.driver.watch(ctx)
 			err = self.migrator.Migrate(uint(schemaVersion))
+			unwatch()
 			if err != nil {
 				return ErrMigratorGeneric().WrapAs(err)
 			}
 
-			self.observer.Info(ctx, "Rollbacked all migrations successfully")
+			self.observer.Infof(ctx, "Rollbacked all migrations successfully in %s",
+				time.Since(start).Round(time.Millisecond))
+
+			self.record(ctx, currentSchemaVersion, uint(schemaVersion))
 
 			return nil
 		}()
@@ -297,31 +866,226 @@ func (self *Migrator) Rollback(ctx context.Context, schemaVersion int) error {
 	}
 }
 
-func (self *Migrator) Close(ctx context.Context) error {
-	err := Utils.Deadline(ctx, func(exceeded <-chan struct{}) error {
-		self.observer.Info(ctx, "Closing migrator")
+// Steps applies n migrations forward when positive or rollbacks them when negative, relative to
+// the current schema version, failing when there are not enough migrations in that direction.
+// TODO: concurrent-safe
+func (self *Migrator) Steps(ctx context.Context, n int) error {
+	self.done = make(chan struct{}, 1)
+
+	self.migrator.LockTimeout = self.lockTimeout(ctx)
+
+	err := Utils.Deadline(ctx, func(exceeded <-chan struct{}) error {
+		err := func() error {
+			currentSchemaVersion, bad, err := self.migrator.Version() // nolint
+			if err != nil && err != migrate.ErrNilVersion {
+				return ErrMigratorGeneric().WrapAs(err)
+			}
+
+			if bad {
+				return ErrMigratorGeneric().Withf("current schema version %d is dirty", currentSchemaVersion)
+			}
+
+			if n == 0 {
+				self.observer.Info(ctx, "No migration steps requested")
+				return nil
+			}
+
+			migrations, err := self.migrations()
+			if err != nil {
+				return ErrMigratorGeneric().Wrap(err)
+			}
+
+			available := 0
+			for _, migration := range migrations {
+				if (n > 0) == (migration.Version > currentSchemaVersion) {
+					available++
+				}
+			}
+
+			requested := max(n, -n)
+			if requested > available {
+				return ErrMigratorGeneric().Withf("%d migration steps requested but only %d available",
+					requested, available)
+			}
+
+			start := time.Now()
+			unwatch := self.driver.watch(ctx)
+			err = self.migrator.Steps(n)
+			unwatch()
+
+			run := 0
+
+			schemaVersion, _, errV := self.migrator.Version()
+			if errV == nil || errV == migrate.ErrNilVersion {
+				for _, migration := range migrations {
+					if migration.Version > min(currentSchemaVersion, schemaVersion) &&
+						migration.Version <= max(currentSchemaVersion, schemaVersion) {
+						run++
+					}
+				}
+
+				self.record(ctx, currentSchemaVersion, schemaVersion)
+			}
+
+			self.observer.Infof(ctx, "%d migration steps requested, %d run in %s",
+				requested, run, time.Since(start).Round(time.Millisecond))
+
+			if err != nil {
+				return ErrMigratorGeneric().WrapAs(err)
+			}
+
+			return nil
+		}()
 
 		select {
-		case self.migrator.GracefulStop <- true:
+		case <-self.done:
 		default:
+			close(self.done)
 		}
 
-		<-self.done
+		return err
+	})
 
-		err, errD := self.migrator.Close()
-		if errD != nil && _MIGRATOR_ERR_DB_ALREADY_CLOSED.MatchString(errD.Error()) {
-			errD = nil
-		}
+	self.migrator.LockTimeout = migrate.DefaultLockTimeout
 
-		err = Utils.CombineErrors(err, errD)
-		if err != nil {
-			return ErrMigratorGeneric().WrapAs(err)
+	switch {
+	case err == nil:
+		return nil
+	case ErrDeadlineExceeded().Is(err):
+		return ErrMigratorTimedOut()
+	default:
+		return ErrMigratorGeneric().Wrap(err)
+	}
+}
+
+// Force sets the schema version clearing its dirty state without running any migration,
+// e.g. after manually fixing a half-applied migration. A clean schema version is just overwritten.
+// TODO: concurrent-safe
+func (self *Migrator) Force(ctx context.Context, schemaVersion int) error {
+	self.done = make(chan struct{}, 1)
+
+	self.migrator.LockTimeout = self.lockTimeout(ctx)
+
+	err := Utils.Deadline(ctx, func(exceeded <-chan struct{}) error {
+		err := func() error {
+			currentSchemaVersion, bad, err := self.migrator.Version() // nolint
+			if err != nil && err != migrate.ErrNilVersion {
+				return ErrMigratorGeneric().WrapAs(err)
+			}
+
+			if bad {
+				self.observer.Warnf(ctx, "Forcing dirty schema version %d to %d", currentSchemaVersion, schemaVersion)
+			} else {
+				self.observer.Infof(ctx, "Forcing schema version %d to %d", currentSchemaVersion, schemaVersion)
+			}
+
+			err = self.migrator.Force(schemaVersion)
+			if err != nil {
+				return ErrMigratorGeneric().WrapAs(err)
+			}
+
+			self.observer.Infof(ctx, "Forced schema version %d", schemaVersion)
+
+			return nil
+		}()
+
+		select {
+		case <-self.done:
+		default:
+			close(self.done)
 		}
 
-		self.observer.Info(ctx, "Closed migrator")
+		return err
+	})
 
+	self.migrator.LockTimeout = migrate.DefaultLockTimeout
+
+	switch {
+	case err == nil:
 		return nil
+	case ErrDeadlineExceeded().Is(err):
+		return ErrMigratorTimedOut()
+	default:
+		return ErrMigratorGeneric().Wrap(err)
+	}
+}
+
+// RecoverAndApply is the explicit recovery path for a dirty schema: it forces the current
+// schema version to clear the dirty state and then applies forward to the desired schema
+// version, holding the migration lock during the whole operation.
+// TODO: concurrent-safe
+func (self *Migrator) RecoverAndApply(ctx context.Context, schemaVersion int) error {
+	self.done = make(chan struct{}, 1)
+
+	self.migrator.LockTimeout = self.lockTimeout(ctx)
+
+	err := Utils.Deadline(ctx, func(exceeded <-chan struct{}) error {
+		err := func() (err error) {
+			err = self.driver.pin(ctx, self.migrator.LockTimeout)
+			if err != nil {
+				return ErrMigratorGeneric().WrapAs(err)
+			}
+
+			defer func() {
+				errU := self.driver.unpin()
+				if errU != nil {
+					err = ErrMigratorGeneric().WrapAs(Utils.CombineErrors(err, errU))
+				}
+			}()
+
+			currentSchemaVersion, bad, err := self.migrator.Version() // nolint
+			if err != nil && err != migrate.ErrNilVersion {
+				return ErrMigratorGeneric().WrapAs(err)
+			}
+
+			if bad {
+				self.observer.Warnf(ctx, "Current schema version %d is dirty, forcing it", currentSchemaVersion)
+
+				err = self.migrator.Force(int(currentSchemaVersion))
+				if err != nil {
+					return ErrMigratorGeneric().WrapAs(err)
+				}
+
+				self.observer.Warnf(ctx, "Forced schema version %d, dirty state cleared", currentSchemaVersion)
+			}
+
+			if currentSchemaVersion == uint(schemaVersion) {
+				self.observer.Warn(ctx, "No migrations to apply after recovery")
+				return nil
+			}
+
+			if currentSchemaVersion > uint(schemaVersion) {
+				return ErrMigratorGeneric().Withf("desired schema version %d behind from current one %d",
+					schemaVersion, currentSchemaVersion)
+			}
+
+			self.observer.Warnf(ctx, "%d migrations to be applied after recovery",
+				schemaVersion-int(currentSchemaVersion))
+
+			start := time.Now()
+
+			err = self.apply(ctx, currentSchemaVersion, uint(schemaVersion))
+			if err != nil {
+				return err
+			}
+
+			self.observer.Warnf(ctx, "Recovered and applied all migrations successfully in %s",
+				time.Since(start).Round(time.Millisecond))
+
+			return nil
+		}()
+
+		select {
+		case <-self.done:
+		default:
+			close(self.done)
+		}
+
+		return err
 	})
+
+	self.migrator.LockTimeout = migrate.DefaultLockTimeout
+
 	switch {
 	case err == nil:
 		return nil
@@ -332,20 +1096,1659 @@ func (self *Migrator) Close(ctx context.Context) error {
 	}
 }
 
-type _migrateLogger struct {
-	observer *Observer
+// ApplyByName applies up to the schema version of the given migration filename,
+// such as 0042_add_orders_index.up.sql, which must exist in the migrations source.
+func (self *Migrator) ApplyByName(ctx context.Context, filename string) error {
+	migrations, err := self.migrations()
+	if err != nil {
+		return ErrMigratorGeneric().Wrap(err)
+	}
+
+	migration, err := source.Parse(filepath.Base(filename))
+	if err == nil {
+		for _, m := range migrations {
+			if m.Version == migration.Version && m.Identifier == migration.Identifier {
+				return self.Apply(ctx, int(migration.Version))
+			}
+		}
+	}
+
+	name := filepath.Base(filename)
+
+	sort.SliceStable(migrations, func(i, j int) bool {
+		return Utils.Levenshtein(name, migrations[i].Raw) < Utils.Levenshtein(name, migrations[j].Raw)
+	})
+
+	matches := make([]string, 0, _MIGRATOR_CLOSEST_MATCHES)
+	for i := 0; i < len(migrations) && i < _MIGRATOR_CLOSEST_MATCHES; i++ {
+		matches = append(matches, migrations[i].Raw)
+	}
+
+	return ErrMigratorGeneric().Withf("migration %s not found, closest matches: %s",
+		filename, strings.Join(matches, ", "))
 }
 
-func _newMigrateLogger(observer *Observer) *_migrateLogger {
-	return &_migrateLogger{
-		observer: observer,
+// Reset drops all the objects of the database, including the migrations and the history, leaving
+// it empty of migrations to be applied from scratch. It is meant for ephemeral test databases and
+// requires AllowDestructive.
+// TODO: concurrent-safe
+func (self *Migrator) Reset(ctx context.Context) error {
+	return self.drop(ctx, true)
+}
+
+// Drop drops all the objects of the database, including the migrations table, leaving it empty.
+// Contrary to Reset, the migrator cannot be used afterwards. It requires AllowDestructive.
+// TODO: concurrent-safe
+func (self *Migrator) Drop(ctx context.Context) error {
+	return self.drop(ctx, false)
+}
+
+// drop drops all the objects of the database, recreating the tables the migrator relies on when resetting.
+func (self *Migrator) drop(ctx context.Context, reset bool) error {
+	operation, doing, done := "drop", "Dropping", "Dropped"
+	if reset {
+		operation, doing, done = "reset", "Resetting", "Reset"
+	}
+
+	if !self.config.AllowDestructive {
+		return ErrMigratorGeneric().Withf("%s requires destructive operations to be allowed", operation)
+	}
+
+	if reset && *self.config.DatabaseDriver != MigratorDriverPostgres {
+		return ErrMigratorGeneric().With("reset is only supported with postgres")
+	}
+
+	self.done = make(chan struct{}, 1)
+
+	self.migrator.LockTimeout = self.lockTimeout(ctx)
+
+	err := Utils.Deadline(ctx, func(exceeded <-chan struct{}) error {
+		err := func() error {
+			self.observer.Warnf(ctx, "%s the %s database, DROPPING ALL ITS OBJECTS", doing, self.config.DatabaseName)
+
+			err := self.migrator.Drop()
+			if err != nil {
+				return ErrMigratorGeneric().WrapAs(err)
+			}
+
+			if reset {
+				// Dropping does not recreate the tables the migrator relies on
+				table := postgres.DefaultMigrationsTable
+				if self.config.MigrationsTable != nil {
+					table = *self.config.MigrationsTable
+				}
+
+				_, err = self.db.ExecContext(ctx, fmt.Sprintf(_MIGRATOR_VERSION_TABLE_DDL, table))
+				if err != nil {
+					return ErrMigratorGeneric().WrapAs(err)
+				}
+
+				if self.config.History {
+					_, err = self.db.ExecContext(ctx,
+						fmt.Sprintf(_MIGRATOR_HISTORY_TABLE_DDL, _migratorHistoryTable(self.config)))
+					if err != nil {
+						return ErrMigratorGeneric().WrapAs(err)
+					}
+				}
+			}
+
+			self.observer.Warnf(ctx, "%s the %s database", done, self.config.DatabaseName)
+
+			return nil
+		}()
+
+		select {
+		case <-self.done:
+		default:
+			close(self.done)
+		}
+
+		return err
+	})
+
+	self.migrator.LockTimeout = migrate.DefaultLockTimeout
+
+	switch {
+	case err == nil:
+		return nil
+	case ErrDeadlineExceeded().Is(err):
+		return ErrMigratorTimedOut()
+	default:
+		return ErrMigratorGeneric().Wrap(err)
 	}
 }
 
-func (self _migrateLogger) Printf(format string, v ...any) {
-	self.observer.Infof(context.Background(), strings.TrimSpace(format), v...)
+// TestApply applies the same migrations up to the desired schema version against the shadow
+// database, e.g. a clone of the production schema, leaving the primary database untouched.
+func (self *Migrator) TestApply(ctx context.Context, shadowConfig MigratorConfig, schemaVersion int) error {
+	shadowConfig.MigrationsPath = ptr(strings.TrimPrefix(*self.config.MigrationsPath, "file://"))
+	shadowConfig.MigrationsFS = self.config.MigrationsFS
+
+	err := func() error {
+		shadow, err := NewMigrator(ctx, self.observer, shadowConfig, nil)
+		if err != nil {
+			return err
+		}
+
+		err = shadow.Apply(ctx, schemaVersion)
+
+		return Utils.CombineErrors(err, shadow.Close(ctx))
+	}()
+	switch {
+	case err == nil:
+		self.observer.Infof(ctx, "Desired schema version %d test applied to the shadow %s database",
+			schemaVersion, shadowConfig.DatabaseName)
+		return nil
+	case ErrMigratorTimedOut().Is(err):
+		return ErrMigratorTimedOut().With("shadow run").Wrap(err)
+	default:
+		return ErrMigratorGeneric().With("shadow run failed").Wrap(err)
+	}
 }
 
-func (self _migrateLogger) Verbose() bool {
-	return false
+// Version returns the current schema version and whether it is dirty,
+// which is version 0 when no migration has been applied yet.
+func (self *Migrator) Version(ctx context.Context) (uint, bool, error) {
+	var version uint
+	var dirty bool
+
+	err := Utils.Deadline(ctx, func(exceeded <-chan struct{}) error {
+		currentSchemaVersion, bad, err := self.migrator.Version() // nolint
+		if err != nil && err != migrate.ErrNilVersion {
+			return ErrMigratorGeneric().WrapAs(err)
+		}
+
+		version = currentSchemaVersion
+		dirty = bad
+
+		return nil
+	})
+	switch {
+	case err == nil:
+		return version, dirty, nil
+	case ErrDeadlineExceeded().Is(err):
+		return 0, false, ErrMigratorTimedOut()
+	default:
+		return 0, false, ErrMigratorGeneric().Wrap(err)
+	}
+}
+
+// Ping checks the database is reachable with a round-trip, e.g. for a health check
+// to tell an unreachable database apart from an outdated schema version.
+func (self *Migrator) Ping(ctx context.Context) error {
+	err := Utils.Deadline(ctx, func(exceeded <-chan struct{}) error {
+		err := self.db.PingContext(ctx)
+		if err != nil {
+			return ErrMigratorGeneric().WrapAs(err)
+		}
+
+		return nil
+	})
+	switch {
+	case err == nil:
+		return nil
+	case ErrDeadlineExceeded().Is(err):
+		return ErrMigratorTimedOut()
+	default:
+		return ErrMigratorGeneric().Wrap(err)
+	}
+}
+
+// Status returns the current schema version along with the versions of the migrations source
+// pending to be applied and the latest one, which is 0 when the source has no migrations.
+func (self *Migrator) Status(ctx context.Context) (MigratorStatus, error) {
+	var status MigratorStatus
+
+	err := Utils.Deadline(ctx, func(exceeded <-chan struct{}) error {
+		currentSchemaVersion, bad, err := self.migrator.Version() // nolint
+		if err != nil && err != migrate.ErrNilVersion {
+			return ErrMigratorGeneric().WrapAs(err)
+		}
+
+		migrations, err := self.migrations()
+		if err != nil {
+			return ErrMigratorGeneric().Wrap(err)
+		}
+
+		status = MigratorStatus{
+			Current: currentSchemaVersion,
+			Dirty:   bad,
+			Pending: make([]uint, 0, len(migrations)),
+		}
+
+		for _, migration := range migrations {
+			if migration.Version > currentSchemaVersion {
+				status.Pending = append(status.Pending, migration.Version)
+			}
+
+			status.Latest = migration.Version
+		}
+
+		return nil
+	})
+	switch {
+	case err == nil:
+		return status, nil
+	case ErrDeadlineExceeded().Is(err):
+		return MigratorStatus{}, ErrMigratorTimedOut()
+	default:
+		return MigratorStatus{}, ErrMigratorGeneric().Wrap(err)
+	}
+}
+
+// StatusJSON returns a JSON report of the migrations state for tooling, where the target
+// is the latest schema version available in the migrations source, if any.
+func (self *Migrator) StatusJSON(ctx context.Context) ([]byte, error) {
+	status, err := self.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var target *uint
+	if status.Latest > 0 {
+		target = ptr(status.Latest)
+	}
+
+	report, err := json.Marshal(struct { // nolint
+		CurrentVersion  uint   `json:"current_version"`
+		Dirty           bool   `json:"dirty"`
+		Target          *uint  `json:"target,omitempty"`
+		PendingVersions []uint `json:"pending_versions"`
+		DatabaseName    string `json:"database_name"`
+	}{
+		CurrentVersion:  status.Current,
+		Dirty:           status.Dirty,
+		Target:          target,
+		PendingVersions: status.Pending,
+		DatabaseName:    self.config.DatabaseName,
+	})
+	if err != nil {
+		return nil, ErrMigratorGeneric().Wrap(err)
+	}
+
+	return report, nil
+}
+
+// _migratorHistoryTable returns the history table of the migrator, which is per migrations table,
+// so the migrators of several apps sharing the same database do not record into the same one.
+func _migratorHistoryTable(config MigratorConfig) string {
+	if config.MigrationsTable != nil {
+		return *config.MigrationsTable + _MIGRATOR_HISTORY_TABLE_SUFFIX
+	}
+
+	return _MIGRATOR_HISTORY_TABLE
+}
+
+// History returns the migrations applied or rollbacked by the migrator, oldest first,
+// which are only recorded when History is enabled.
+func (self *Migrator) History(ctx context.Context) ([]MigratorHistoryEntry, error) {
+	if !self.config.History {
+		return nil, ErrMigratorGeneric().With("history is not enabled")
+	}
+
+	var history []MigratorHistoryEntry
+
+	err := Utils.Deadline(ctx, func(exceeded <-chan struct{}) error {
+		rows, err := self.db.QueryContext(ctx, fmt.Sprintf(
+			"SELECT version, direction, applied_at FROM %s ORDER BY applied_at, version",
+			_migratorHistoryTable(self.config)))
+		if err != nil {
+			return ErrMigratorGeneric().WrapAs(err)
+		}
+		defer rows.Close()
+
+		entries := make([]MigratorHistoryEntry, 0)
+
+		for rows.Next() {
+			var entry MigratorHistoryEntry
+
+			err = rows.Scan(&entry.Version, &entry.Direction, &entry.AppliedAt)
+			if err != nil {
+				return ErrMigratorGeneric().WrapAs(err)
+			}
+
+			entries = append(entries, entry)
+		}
+
+		err = rows.Err()
+		if err != nil {
+			return ErrMigratorGeneric().WrapAs(err)
+		}
+
+		history = entries
+
+		return nil
+	})
+	switch {
+	case err == nil:
+		return history, nil
+	case ErrDeadlineExceeded().Is(err):
+		return nil, ErrMigratorTimedOut()
+	default:
+		return nil, ErrMigratorGeneric().Wrap(err)
+	}
+}
+
+// lockTimeout returns how long the migration lock is waited for, which is the WaitForLock when set,
+// bounded by the context deadline. Once the lock is acquired, the deadline no longer interrupts the
+// running migration, whose statements are only bounded by the StatementTimeout, when set, which
+// the kit:timeout directive of a migration can only lower for its own statements.
+func (self *Migrator) lockTimeout(ctx context.Context) time.Duration {
+	timeout := migrate.DefaultLockTimeout
+	if self.config.WaitForLock > 0 {
+		timeout = self.config.WaitForLock
+	}
+
+	if ctxDeadline, ok := ctx.Deadline(); ok {
+		if self.config.WaitForLock <= 0 || time.Until(ctxDeadline) < timeout {
+			timeout = time.Until(ctxDeadline)
+		}
+	}
+
+	return timeout
+}
+
+// apply migrates up to the schema version, one migration at a time when the InterStepDelay is set,
+// and then records the applied migrations and runs the post-apply checks.
+func (self *Migrator) apply(ctx context.Context, fromVersion uint, toVersion uint) error {
+	var err error
+
+	unwatch := self.driver.watch(ctx)
+	if self.config.InterStepDelay > 0 {
+		err = self.step(ctx, fromVersion, toVersion)
+	} else {
+		err = self.migrator.Migrate(toVersion)
+	}
+	unwatch()
+	if err != nil {
+		return ErrMigratorGeneric().WrapAs(err)
+	}
+
+	self.record(ctx, fromVersion, toVersion)
+
+	return self.check(ctx)
+}
+
+// step applies the migrations one at a time up to the schema version, pausing between them.
+func (self *Migrator) step(ctx context.Context, fromVersion uint, toVersion uint) error {
+	migrations, err := self.migrations()
+	if err != nil {
+		return err
+	}
+
+	steps := 0
+	found := false
+
+	for _, migration := range migrations {
+		if migration.Version > fromVersion && migration.Version <= toVersion {
+			steps++
+			found = found || migration.Version == toVersion
+		}
+	}
+
+	if !found {
+		return ErrMigratorGeneric().Withf("migration %d not found", toVersion)
+	}
+
+	for step := 1; ; step++ {
+		err = self.migrator.Steps(1)
+		if err != nil {
+			return err
+		}
+
+		version, _, err := self.migrator.Version()
+		if err != nil {
+			return err
+		}
+
+		self.observer.Infof(ctx, "Applied migration %d, %d/%d", version, min(step, steps), steps)
+
+		if version >= toVersion {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(self.config.InterStepDelay):
+		}
+	}
+}
+
+// check runs all the post-apply checks, failing if any of them fails.
+func (self *Migrator) check(ctx context.Context) error {
+	var errs error
+
+	for _, check := range self.config.PostApplyChecks {
+		err := func() error {
+			rows, err := self.db.QueryContext(ctx, check.Query)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			if check.Expect != nil {
+				err = check.Expect(rows)
+				if err != nil {
+					return err
+				}
+			}
+
+			return rows.Err()
+		}()
+		if err != nil {
+			self.observer.Errorf(ctx, "Post-apply check %s failed: %v", check.Name, err)
+			errs = Utils.CombineErrors(errs, errors.Wrapf(err, "post-apply check %s", check.Name))
+			continue
+		}
+
+		self.observer.Infof(ctx, "Post-apply check %s passed", check.Name)
+	}
+
+	if errs != nil {
+		return ErrMigratorGeneric().Wrap(errs)
+	}
+
+	return nil
+}
+
+// record records in the history the migrations crossed from one schema version to another.
+// The migrations already succeeded, so failing to record them is only logged.
+func (self *Migrator) record(ctx context.Context, fromVersion uint, toVersion uint) {
+	if !self.config.History || fromVersion == toVersion {
+		return
+	}
+
+	migrations, err := self.migrations()
+	if err != nil {
+		self.observer.Error(ctx, ErrMigratorGeneric().Wrap(err))
+		return
+	}
+
+	direction := _MIGRATOR_DIRECTION_UP
+	if fromVersion > toVersion {
+		direction = _MIGRATOR_DIRECTION_DOWN
+		sort.Slice(migrations, func(i, j int) bool {
+			return migrations[i].Version > migrations[j].Version
+		})
+	}
+
+	for _, migration := range migrations {
+		if migration.Version <= min(fromVersion, toVersion) || migration.Version > max(fromVersion, toVersion) {
+			continue
+		}
+
+		_, err = self.db.ExecContext(ctx, fmt.Sprintf(
+			"INSERT INTO %s (version, direction) VALUES ($1, $2)", _migratorHistoryTable(self.config)),
+			migration.Version, direction)
+		if err != nil {
+			self.observer.Error(ctx, ErrMigratorGeneric().Wrap(err))
+			return
+		}
+	}
+}
+
+// migrations returns the up migrations of the source in ascending version order,
+// with the raw name set to their filename.
+func (self *Migrator) migrations() ([]source.Migration, error) {
+	entries, err := fs.ReadDir(_migrationsFS(self.config), ".")
+	if err != nil {
+		return nil, ErrMigratorGeneric().WrapAs(err)
+	}
+
+	// The source driver does not expose the file names, which keep the padding of the versions
+	filenames := make(map[uint]string)
+
+	for _, entry := range entries {
+		migration, err := source.Parse(entry.Name())
+		if err == nil && !entry.IsDir() && migration.Direction == source.Up {
+			filenames[migration.Version] = entry.Name()
+		}
+	}
+
+	migrations := make([]source.Migration, 0)
+
+	version, err := self.source.First()
+
+	for err == nil {
+		reader, identifier, errR := self.source.ReadUp(version)
+		switch {
+		case errR == nil:
+			errR = reader.Close()
+			if errR != nil {
+				return nil, ErrMigratorGeneric().WrapAs(errR)
+			}
+
+			migrations = append(migrations, source.Migration{
+				Version:    version,
+				Identifier: identifier,
+				Direction:  source.Up,
+				Raw:        filenames[version],
+			})
+		case errors.Is(errR, os.ErrNotExist):
+		default:
+			return nil, ErrMigratorGeneric().WrapAs(errR)
+		}
+
+		version, err = self.source.Next(version)
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, ErrMigratorGeneric().WrapAs(err)
+	}
+
+	return migrations, nil
+}
+
+func (self *Migrator) Close(ctx context.Context) error {
+	err := Utils.Deadline(ctx, func(exceeded <-chan struct{}) error {
+		self.observer.Info(ctx, "Closing migrator")
+
+		select {
+		case self.migrator.GracefulStop <- true:
+		default:
+		}
+
+		<-self.done
+
+		err, errD := self.migrator.Close()
+		if errD != nil && _MIGRATOR_ERR_DB_ALREADY_CLOSED.MatchString(errD.Error()) {
+			errD = nil
+		}
+
+		if !self.borrowed {
+			errD = Utils.CombineErrors(errD, self.db.Close())
+		}
+
+		err = Utils.CombineErrors(err, errD)
+		if err != nil {
+			return ErrMigratorGeneric().WrapAs(err)
+		}
+
+		self.observer.Info(ctx, "Closed migrator")
+
+		return nil
+	})
+	switch {
+	case err == nil:
+		return nil
+	case ErrDeadlineExceeded().Is(err):
+		return ErrMigratorTimedOut()
+	default:
+		return ErrMigratorGeneric().Wrap(err)
+	}
+}
+
+// GenerateMigration creates the empty up and down files of a new migration in the directory, creating
+// it when missing, versioned after the highest existing version with its same zero padding.
+func GenerateMigration(dir string, name string) (string, string, error) {
+	dir = filepath.Clean(dir)
+
+	slug := strings.Trim(_MIGRATOR_SLUG_SEPARATORS.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if slug == "" {
+		return "", "", ErrMigratorGeneric().Withf("migration name %s is empty", name)
+	}
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return "", "", ErrMigratorGeneric().Wrap(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", "", ErrMigratorGeneric().Wrap(err)
+	}
+
+	latest := uint(0)
+	digits := _MIGRATOR_DEFAULT_VERSION_DIGITS
+
+	for _, entry := range entries {
+		migration, err := source.Parse(entry.Name())
+		if entry.IsDir() || err != nil {
+			continue
+		}
+
+		if migration.Identifier == slug {
+			return "", "", ErrMigratorGeneric().Withf("migration %s already exists", entry.Name())
+		}
+
+		if migration.Version >= latest {
+			latest = migration.Version
+			digits = strings.Index(entry.Name(), "_")
+		}
+	}
+
+	prefix := filepath.Join(dir, fmt.Sprintf("%0*d_%s", digits, latest+1, slug))
+	upPath := prefix + "." + string(source.Up) + ".sql"
+	downPath := prefix + "." + string(source.Down) + ".sql"
+
+	for _, path := range []string{upPath, downPath} {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err != nil {
+			return "", "", ErrMigratorGeneric().Wrap(err)
+		}
+
+		err = file.Close()
+		if err != nil {
+			return "", "", ErrMigratorGeneric().Wrap(err)
+		}
+	}
+
+	return upPath, downPath, nil
+}
+
+// ValidateMigrations validates that every migration file of the path has both up and down files,
+// and that the versions are contiguous from 1 without duplicates, listing every problem found.
+func ValidateMigrations(path string) error {
+	return _validateMigrations(os.DirFS(filepath.Clean(path)))
+}
+
+func _validateMigrations(migrations fs.FS) error {
+	entries, err := fs.ReadDir(migrations, ".")
+	if err != nil {
+		return ErrMigratorGeneric().Wrap(err)
+	}
+
+	files := make(map[uint]map[source.Direction][]string)
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		migration, err := source.Parse(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		if files[migration.Version] == nil {
+			files[migration.Version] = make(map[source.Direction][]string)
+		}
+
+		files[migration.Version][migration.Direction] = append(
+			files[migration.Version][migration.Direction], entry.Name())
+	}
+
+	versions := make([]uint, 0, len(files))
+	for version := range files {
+		versions = append(versions, version)
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i] < versions[j]
+	})
+
+	problems := make([]string, 0)
+
+	next := uint(1)
+
+	for _, version := range versions {
+		switch {
+		case version < 1:
+			problems = append(problems, "version 0 is not allowed")
+		case version == next+1:
+			problems = append(problems, fmt.Sprintf("version %d is missing", next))
+		case version > next:
+			problems = append(problems, fmt.Sprintf("versions %d to %d are missing", next, version-1))
+		}
+
+		next = version + 1
+
+		for _, direction := range []source.Direction{source.Up, source.Down} {
+			switch names := files[version][direction]; {
+			case len(names) < 1:
+				problems = append(problems, fmt.Sprintf("version %d has no %s migration", version, direction))
+			case len(names) > 1:
+				problems = append(problems, fmt.Sprintf("version %d has duplicated %s migrations: %s",
+					version, direction, strings.Join(names, ", ")))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return ErrMigratorGeneric().Withf("invalid migrations: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// _migratorDSN builds the DSN of the configured database driver, with the golang-migrate parameters.
+func _migratorDSN(config MigratorConfig) (string, error) {
+	var dsn string
+	var err error
+
+	switch *config.DatabaseDriver {
+	case MigratorDriverPostgres:
+		// The credentials are escaped, as they can contain reserved characters such as @ or /
+		dsn = (&url.URL{
+			Scheme:   _MIGRATOR_POSTGRES_SCHEME,
+			User:     url.UserPassword(config.DatabaseUser, config.DatabasePassword),
+			Host:     net.JoinHostPort(config.DatabaseHost, strconv.Itoa(config.DatabasePort)),
+			Path:     "/" + config.DatabaseName,
+			RawQuery: url.Values{"sslmode": {config.DatabaseSSLMode}}.Encode(),
+		}).String()
+
+		if config.DSN != nil {
+			dsn = *config.DSN
+		}
+
+		dsn, err = _appendDSNParam(dsn, _MIGRATOR_MULTI_STATEMENT_PARAM, "true")
+		if err != nil {
+			return "", ErrMigratorGeneric().Wrap(err)
+		}
+
+		dsn, err = _appendDSNParam(dsn, _MIGRATOR_APPLICATION_NAME_PARAM, config.ApplicationName)
+		if err != nil {
+			return "", ErrMigratorGeneric().Wrap(err)
+		}
+
+		if config.MigrationsTable != nil {
+			dsn, err = _appendDSNParam(dsn, _MIGRATOR_TABLE_PARAM, *config.MigrationsTable)
+			if err != nil {
+				return "", ErrMigratorGeneric().Wrap(err)
+			}
+		}
+
+		if config.StatementTimeout != nil {
+			dsn, err = _appendDSNParam(dsn, _MIGRATOR_TIMEOUT_PARAM,
+				strconv.FormatInt(config.StatementTimeout.Milliseconds(), 10))
+			if err != nil {
+				return "", ErrMigratorGeneric().Wrap(err)
+			}
+		}
+
+		for _, key := range _sortedKeys(config.DatabaseParams) {
+			dsn, err = _appendDSNParam(dsn, key, config.DatabaseParams[key])
+			if err != nil {
+				return "", ErrMigratorGeneric().Wrap(err)
+			}
+		}
+	case MigratorDriverMySQL:
+		if config.History || config.AtomicRange || config.Dialer != nil || config.AdvisoryLockID != nil {
+			return "", ErrMigratorGeneric().With(
+				"history, atomic range, dialer and advisory lock id are only supported with postgres")
+		}
+
+		// The credentials are escaped, as they can contain reserved characters such as @ or ?,
+		// and unescaped back by golang-migrate and _mysqlConfig
+		dsn = fmt.Sprintf(
+			_MIGRATOR_MYSQL_DSN,
+			url.QueryEscape(config.DatabaseUser),
+			url.QueryEscape(config.DatabasePassword),
+			config.DatabaseHost,
+			config.DatabasePort,
+			config.DatabaseName,
+		)
+
+		// The allow and prefer modes are left to the driver default, as golang-migrate reads any tls value
+		// other than a bool or skip-verify, such as preferred, as a custom CA from the x-tls-ca parameter
+		if mode, ok := _MIGRATOR_MYSQL_TLS_MODES[config.DatabaseSSLMode]; ok {
+			dsn = _appendMySQLDSNParam(dsn, _MIGRATOR_MYSQL_TLS_PARAM, mode)
+		}
+
+		if config.DSN != nil {
+			dsn = *config.DSN
+		}
+
+		dsn = _appendMySQLDSNParam(dsn, _MIGRATOR_MYSQL_MULTI_STATEMENT, "true")
+
+		if config.MigrationsTable != nil {
+			dsn = _appendMySQLDSNParam(dsn, _MIGRATOR_TABLE_PARAM, *config.MigrationsTable)
+		}
+
+		if config.StatementTimeout != nil {
+			dsn = _appendMySQLDSNParam(dsn, _MIGRATOR_TIMEOUT_PARAM,
+				strconv.FormatInt(config.StatementTimeout.Milliseconds(), 10))
+		}
+
+		for _, key := range _sortedKeys(config.DatabaseParams) {
+			dsn = _appendMySQLDSNParam(dsn, key, config.DatabaseParams[key])
+		}
+	default:
+		return "", ErrMigratorGeneric().Withf("unknown database driver %s", *config.DatabaseDriver)
+	}
+
+	return dsn, nil
+}
+
+// _migratorLockDatabaseName returns the database name as postgres.Open takes it from the DSN path,
+// so that the advisory lock ID does not depend on how the migrator was built.
+func _migratorLockDatabaseName(database string) string {
+	return "/" + database
+}
+
+// _migrationsFS returns the migrations FS when set, or else the one of the migrations path.
+func _migrationsFS(config MigratorConfig) fs.FS {
+	if config.MigrationsFS != nil {
+		return config.MigrationsFS
+	}
+
+	return os.DirFS(strings.TrimPrefix(*config.MigrationsPath, "file://"))
+}
+
+// _appendDSNParam appends the parameter to the DSN when missing, keeping the rest of it verbatim.
+func _appendDSNParam(dsn string, key string, value string) (string, error) {
+	dsnURL, err := url.Parse(dsn)
+	if err != nil {
+		return "", err
+	}
+
+	if dsnURL.Query().Has(key) {
+		return dsn, nil
+	}
+
+	switch {
+	case dsnURL.RawQuery != "":
+		dsn += "&"
+	case !dsnURL.ForceQuery:
+		dsn += "?"
+	}
+
+	return dsn + url.QueryEscape(key) + "=" + url.QueryEscape(value), nil
+}
+
+// _isMigratorRetryable reports whether the error is a transient connection failure,
+// such as a refused connection or a database server still starting up.
+func _isMigratorRetryable(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH) {
+		return true
+	}
+
+	// Not every driver error keeps the chain of its cause
+	return _MIGRATOR_ERR_TRANSIENT.MatchString(err.Error())
+}
+
+type _migratorRetryClassifier func(err error) bool
+
+func (self _migratorRetryClassifier) Classify(err error) retrier.Action {
+	switch {
+	case err == nil:
+		return retrier.Succeed
+	case self(err):
+		return retrier.Retry
+	default:
+		return retrier.Fail
+	}
+}
+
+// _openMigrateSource opens the migrations FS when set, or else the migrations path.
+func _openMigrateSource(config MigratorConfig) (source.Driver, string, error) {
+	if config.MigrationsFS != nil {
+		src, err := iofs.New(config.MigrationsFS, ".")
+		return src, _MIGRATOR_FS_SOURCE_NAME, err
+	}
+
+	src, err := source.Open(*config.MigrationsPath)
+
+	return src, _MIGRATOR_SOURCE_NAME, err
+}
+
+func _sortedKeys(params map[string]string) []string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// _appendMySQLDSNParam appends the parameter to the MySQL DSN when missing, which is not a valid
+// URL to be parsed, as its address is like tcp(host:port).
+func _appendMySQLDSNParam(dsn string, key string, value string) string {
+	_, query, found := strings.Cut(dsn, "?")
+
+	for _, param := range strings.Split(query, "&") {
+		if name, _, _ := strings.Cut(param, "="); name == key {
+			return dsn
+		}
+	}
+
+	switch {
+	case query != "":
+		dsn += "&"
+	case !found:
+		dsn += "?"
+	}
+
+	return dsn + url.QueryEscape(key) + "=" + url.QueryEscape(value)
+}
+
+// _mysqlConfig parses the MySQL DSN as golang-migrate does, unescaping the credentials,
+// without the golang-migrate custom parameters, which are not understood by the database.
+func _mysqlConfig(dsn string) (*gomysql.Config, error) {
+	mysqlConfig, err := gomysql.ParseDSN(strings.TrimPrefix(dsn, string(MigratorDriverMySQL)+"://"))
+	if err != nil {
+		return nil, err
+	}
+
+	mysqlConfig.User, err = url.QueryUnescape(mysqlConfig.User)
+	if err != nil {
+		return nil, err
+	}
+
+	mysqlConfig.Passwd, err = url.QueryUnescape(mysqlConfig.Passwd)
+	if err != nil {
+		return nil, err
+	}
+
+	for key := range mysqlConfig.Params {
+		if strings.HasPrefix(key, "x-") {
+			delete(mysqlConfig.Params, key)
+		}
+	}
+
+	return mysqlConfig, nil
+}
+
+func _openMigrateDB(driver MigratorDriver, dsn string,
+	dialer func(ctx context.Context, network string, address string) (net.Conn, error)) (*sql.DB, error) {
+	if driver == MigratorDriverMySQL {
+		mysqlConfig, err := _mysqlConfig(dsn)
+		if err != nil {
+			return nil, err
+		}
+
+		return sql.Open(string(driver), mysqlConfig.FormatDSN())
+	}
+
+	dsnURL, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// The golang-migrate custom parameters are not understood by the database
+	dsn = migrate.FilterCustomQuery(dsnURL).String()
+
+	if dialer == nil {
+		return sql.Open(string(driver), dsn)
+	}
+
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	connector.Dialer(_migrateDialer(dialer))
+
+	return sql.OpenDB(connector), nil
+}
+
+// _openMigrateDatabase opens the golang-migrate database driver. With a dialer, the driver is built
+// over its own connection pool as postgres.Open does, which cannot be given a dialer.
+func _openMigrateDatabase(dsn string,
+	dialer func(ctx context.Context, network string, address string) (net.Conn, error)) (database.Driver, error) {
+	if dialer == nil {
+		return database.Open(dsn)
+	}
+
+	dsnURL, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &postgres.Config{
+		// Same database name as postgres.Open, as it is part of the advisory lock ID
+		DatabaseName:    dsnURL.Path,
+		MigrationsTable: dsnURL.Query().Get(_MIGRATOR_TABLE_PARAM),
+	}
+
+	if param := dsnURL.Query().Get(_MIGRATOR_MULTI_STATEMENT_PARAM); param != "" {
+		config.MultiStatementEnabled, err = strconv.ParseBool(param)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if param := dsnURL.Query().Get(_MIGRATOR_TIMEOUT_PARAM); param != "" {
+		timeout, err := strconv.Atoi(param)
+		if err != nil {
+			return nil, err
+		}
+
+		config.StatementTimeout = time.Duration(timeout) * time.Millisecond
+	}
+
+	db, err := _openMigrateDB(MigratorDriverPostgres, dsn, dialer)
+	if err != nil {
+		return nil, err
+	}
+
+	instance, err := postgres.WithInstance(db, config)
+	if err != nil {
+		return nil, Utils.CombineErrors(err, db.Close())
+	}
+
+	return instance, nil
+}
+
+type _migrateDialer func(ctx context.Context, network string, address string) (net.Conn, error)
+
+func (self _migrateDialer) Dial(network string, address string) (net.Conn, error) {
+	return self(context.Background(), network, address)
+}
+
+func (self _migrateDialer) DialTimeout(network string, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return self(ctx, network, address)
+}
+
+func (self _migrateDialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	return self(ctx, network, address)
+}
+
+// _isOlderVersion compares the numeric parts of the versions, ignoring any trailing
+// build information such as the one of "14.5 (Debian 14.5-1.pgdg110+1)".
+func _isOlderVersion(version string, minVersion string) (bool, error) {
+	parse := func(version string) ([]int, error) {
+		numeric := strings.TrimSpace(version)
+
+		end := strings.IndexFunc(numeric, func(r rune) bool {
+			return r != '.' && (r < '0' || r > '9')
+		})
+		if end >= 0 {
+			numeric = numeric[:end]
+		}
+
+		parts := strings.Split(strings.Trim(numeric, "."), ".")
+		numbers := make([]int, 0, len(parts))
+
+		for _, part := range parts {
+			number, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, ErrMigratorGeneric().Withf("malformed version %s", version)
+			}
+
+			numbers = append(numbers, number)
+		}
+
+		return numbers, nil
+	}
+
+	current, err := parse(version)
+	if err != nil {
+		return false, err
+	}
+
+	minimum, err := parse(minVersion)
+	if err != nil {
+		return false, err
+	}
+
+	for i := 0; i < max(len(current), len(minimum)); i++ {
+		var c, m int
+
+		if i < len(current) {
+			c = current[i]
+		}
+
+		if i < len(minimum) {
+			m = minimum[i]
+		}
+
+		if c != m {
+			return c < m, nil
+		}
+	}
+
+	return false, nil
+}
+
+type _migrateLogger struct {
+	observer *Observer
+	verbose  bool
+}
+
+func _newMigrateLogger(observer *Observer, verbose bool) *_migrateLogger {
+	return &_migrateLogger{
+		observer: observer,
+		verbose:  verbose,
+	}
+}
+
+func (self _migrateLogger) Printf(format string, v ...any) {
+	self.observer.Infof(context.Background(), strings.TrimSpace(format), v...)
+}
+
+func (self _migrateLogger) Verbose() bool {
+	return self.verbose
+}
+
+// _migrateDriver wraps the golang-migrate database driver so the migrator can pin
+// the migration lock across several golang-migrate operations.
+type _migrateDriver struct {
+	database.Driver
+	observer      *Observer
+	dialect       MigratorDriver
+	verbose       bool
+	warnPeriod    time.Duration
+	lockWait      time.Duration
+	lockID        *int64
+	transactional bool
+	beforeEach    func(ctx context.Context, version uint) error
+	afterEach     func(ctx context.Context, version uint, err error)
+	mutex         sync.Mutex
+	pinned        bool
+	running       bool
+	started       time.Time
+	ctx           context.Context
+	version       atomic.Int64
+}
+
+func _newMigrateDriver(observer *Observer, driver database.Driver, config MigratorConfig) *_migrateDriver {
+	var lockID *int64
+	if config.AdvisoryLockID != nil {
+		// Already validated by the constructors
+		id, _ := strconv.ParseInt(*config.AdvisoryLockID, 10, 64)
+		lockID = &id
+	}
+
+	return &_migrateDriver{
+		Driver:        driver,
+		observer:      observer,
+		dialect:       *config.DatabaseDriver,
+		verbose:       config.Verbose,
+		warnPeriod:    *config.RunningWarnPeriod,
+		lockWait:      config.WaitForLock,
+		lockID:        lockID,
+		transactional: config.Transactional,
+		beforeEach:    config.BeforeEach,
+		afterEach:     config.AfterEach,
+	}
+}
+
+// pin takes the database lock until unpinned, waiting for it no longer than the timeout
+// or until the context is done.
+func (self *_migrateDriver) pin(ctx context.Context, timeout time.Duration) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if self.pinned {
+		return database.ErrLocked
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := self.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	self.pinned = true
+
+	return nil
+}
+
+func (self *_migrateDriver) unpin() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if !self.pinned {
+		return database.ErrNotLocked
+	}
+
+	self.pinned = false
+
+	return self.unlock()
+}
+
+func (self *_migrateDriver) Lock() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if self.pinned {
+		return nil
+	}
+
+	if self.lockWait <= 0 {
+		return self.lock()
+	}
+
+	ctx := self.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, self.lockWait)
+	defer cancel()
+
+	return self.acquire(ctx)
+}
+
+// acquire acquires the database lock, retrying it until the context is done.
+// Must be called with the mutex held.
+func (self *_migrateDriver) acquire(ctx context.Context) error {
+	start := time.Now()
+
+	if self.warnPeriod > 0 {
+		go func() {
+			ticker := time.NewTicker(self.warnPeriod)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					self.observer.Infof(ctx, "Still waiting for migration lock after %s",
+						time.Since(start).Round(time.Second))
+				}
+			}
+		}()
+	}
+
+	sleep := func(delay time.Duration) {
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+	}
+
+	return Utils.WithSleep(sleep).ExponentialRetry(
+		_MIGRATOR_LOCK_RETRY_ATTEMPTS, _MIGRATOR_LOCK_RETRY_INITIAL_DELAY, _MIGRATOR_LOCK_RETRY_LIMIT_DELAY,
+		retrier.BlacklistClassifier{migrate.ErrLockTimeout}, func(attempt int) error {
+			select {
+			case <-ctx.Done():
+				return migrate.ErrLockTimeout
+			default:
+			}
+
+			return self.tryLock(ctx)
+		})
+}
+
+// tryLock takes the database lock waiting for it no longer than the context deadline, nor than
+// the retry limit delay so a canceled context is noticed, as the postgres advisory locks otherwise
+// wait indefinitely. The MySQL one already gives up after a while.
+func (self *_migrateDriver) tryLock(ctx context.Context) error {
+	if self.dialect != MigratorDriverPostgres {
+		return self.lock()
+	}
+
+	wait := _MIGRATOR_LOCK_RETRY_LIMIT_DELAY
+	if ctxDeadline, ok := ctx.Deadline(); ok && time.Until(ctxDeadline) < wait {
+		wait = time.Until(ctxDeadline)
+	}
+
+	// A zero lock_timeout disables it
+	if wait < time.Millisecond {
+		return migrate.ErrLockTimeout
+	}
+
+	err := self.Driver.Run(strings.NewReader(fmt.Sprintf(_MIGRATOR_LOCK_TIMEOUT, wait.Milliseconds())))
+	if err != nil {
+		return err
+	}
+
+	err = self.lock()
+
+	errR := self.Driver.Run(strings.NewReader(_MIGRATOR_LOCK_RESET))
+	if err == nil && errR != nil {
+		// The lock_timeout would otherwise bound the statements of the migrations
+		return Utils.CombineErrors(errR, self.unlock())
+	}
+
+	return Utils.CombineErrors(err, errR)
+}
+
+func (self *_migrateDriver) Unlock() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if self.pinned {
+		return nil
+	}
+
+	return self.unlock()
+}
+
+// lock takes the database lock, which is the custom advisory lock through the driver
+// session, as the golang-migrate one does, when the AdvisoryLockID is set.
+func (self *_migrateDriver) lock() error {
+	if self.lockID == nil {
+		return self.Driver.Lock()
+	}
+
+	return self.Driver.Run(strings.NewReader(fmt.Sprintf(_MIGRATOR_LOCK_QUERY, *self.lockID)))
+}
+
+func (self *_migrateDriver) unlock() error {
+	if self.lockID == nil {
+		return self.Driver.Unlock()
+	}
+
+	return self.Driver.Run(strings.NewReader(fmt.Sprintf(_MIGRATOR_UNLOCK_QUERY, *self.lockID)))
+}
+
+type _migrateDirectives struct {
+	timeout       time.Duration
+	transaction   bool
+	noTransaction bool
+}
+
+// directives parses the kit: directives of the header comments of the migration:
+// kit:timeout=<duration> sets its statement timeout, kit:transaction runs it in a transaction
+// and kit:no-transaction outside of one, which the legacy x-no-transaction directive also does.
+func (self *_migrateDriver) directives(migration []byte) _migrateDirectives {
+	directives := _migrateDirectives{}
+
+	for _, line := range bytes.Split(migration, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+
+		if len(line) == 0 {
+			continue
+		}
+
+		// The header ends with the first line that is not a comment
+		if !bytes.HasPrefix(line, []byte("--")) {
+			break
+		}
+
+		// The legacy directive is still honored, but only in the header as the kit ones
+		if bytes.Contains(line, _MIGRATOR_NO_TRANSACTION_DIRECTIVE) {
+			directives.noTransaction = true
+			continue
+		}
+
+		match := _MIGRATOR_DIRECTIVE.FindSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		key, value, _ := strings.Cut(string(match[1]), "=")
+
+		switch key {
+		case "timeout":
+			timeout, err := time.ParseDuration(value)
+			if err != nil {
+				self.observer.Debugf(context.Background(), "Ignoring malformed migration directive %s", match[1])
+				continue
+			}
+
+			directives.timeout = timeout
+		case "transaction":
+			directives.transaction = true
+		case "no-transaction":
+			directives.noTransaction = true
+		default:
+			self.observer.Debugf(context.Background(), "Ignoring unknown migration directive %s", match[1])
+		}
+	}
+
+	return directives
+}
+
+// Run runs the migration, in a transaction when opted in, with the statement timeout of its directives.
+// Migrations run as is by default, as they can manage their own transactions or run statements
+// such as CREATE INDEX CONCURRENTLY, which cannot run in one.
+func (self *_migrateDriver) Run(migration io.Reader) (err error) {
+	defer func() {
+		if err != nil {
+			self.finish(int(self.version.Load()), err)
+		}
+	}()
+
+	body, err := io.ReadAll(migration)
+	if err != nil {
+		return err
+	}
+
+	// The directives rely on the postgres transactional DDL and statement timeout
+	if self.dialect != MigratorDriverPostgres {
+		return self.run(body)
+	}
+
+	directives := self.directives(body)
+
+	if directives.noTransaction || !(directives.transaction || self.transactional) {
+		if directives.timeout > 0 {
+			err = self.Driver.Run(strings.NewReader(
+				fmt.Sprintf("SET statement_timeout = %d", directives.timeout.Milliseconds())))
+			if err != nil {
+				return err
+			}
+
+			defer func() {
+				err = Utils.CombineErrors(err, self.Driver.Run(strings.NewReader("RESET statement_timeout")))
+			}()
+		}
+
+		return self.run(body)
+	}
+
+	err = self.Driver.Run(strings.NewReader("BEGIN"))
+	if err != nil {
+		return err
+	}
+
+	err = self.runInTransaction(body, directives)
+	if err != nil {
+		return Utils.CombineErrors(err, self.Driver.Run(strings.NewReader("ROLLBACK")))
+	}
+
+	return self.Driver.Run(strings.NewReader("COMMIT"))
+}
+
+// runInTransaction runs the migration within the current transaction, scoping its statement timeout to it.
+func (self *_migrateDriver) runInTransaction(migration []byte, directives _migrateDirectives) error {
+	if directives.timeout <= 0 {
+		return self.run(migration)
+	}
+
+	err := self.Driver.Run(strings.NewReader(
+		fmt.Sprintf("SET LOCAL statement_timeout = %d", directives.timeout.Milliseconds())))
+	if err != nil {
+		return err
+	}
+
+	err = self.run(migration)
+	if err != nil {
+		return err
+	}
+
+	return self.Driver.Run(strings.NewReader("SET LOCAL statement_timeout TO DEFAULT"))
+}
+
+func (self *_migrateDriver) run(migration []byte) error {
+	if !self.verbose {
+		return self.Driver.Run(bytes.NewReader(migration))
+	}
+
+	// Execute the statements one by one, as the multi-statement mode does,
+	// so the last logged statement is the one that failed
+	var err error
+
+	errP := multistmt.Parse(bytes.NewReader(migration), _MIGRATOR_STATEMENT_DELIMITER,
+		postgres.DefaultMultiStatementMaxSize, func(statement []byte) bool {
+			if len(bytes.TrimSpace(statement)) == 0 {
+				return true
+			}
+
+			self.observer.Debugf(context.Background(), "Executing migration statement: %s", bytes.TrimSpace(statement))
+
+			err = self.Driver.Run(bytes.NewReader(statement))
+
+			return err == nil
+		})
+
+	return Utils.CombineErrors(errP, err)
+}
+
+// runAtomically runs the migrations in a single transaction, which relies on the
+// driver executing every statement through the same database session.
+func (self *_migrateDriver) runAtomically(fromVersion int, toVersion int, migrations [][]byte) (err error) {
+	// The cancellation, hooks and warnings handle the range as a single migration to the last version
+	err = self.SetVersion(toVersion, true)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			self.finish(toVersion, err)
+		}
+	}()
+
+	err = self.Driver.Run(strings.NewReader("BEGIN"))
+	if err != nil {
+		return Utils.CombineErrors(err, self.Driver.SetVersion(fromVersion, false))
+	}
+
+	for _, migration := range migrations {
+		err = self.runInTransaction(migration, self.directives(migration))
+		if err != nil {
+			errR := self.Driver.Run(strings.NewReader("ROLLBACK"))
+			if errR != nil {
+				// Leave the schema version dirty, the state of the transaction is unknown
+				return Utils.CombineErrors(err, errR)
+			}
+
+			return Utils.CombineErrors(err, self.Driver.SetVersion(fromVersion, false))
+		}
+	}
+
+	err = self.Driver.Run(strings.NewReader("COMMIT"))
+	if err != nil {
+		return err
+	}
+
+	return self.SetVersion(toVersion, false)
+}
+
+// watch stops the migrations once the context is done, until unwatched. It is used instead of the
+// golang-migrate GracefulStop channel because a graceful stop cannot be undone for later operations.
+// Meanwhile, it periodically warns about the migrations still running.
+func (self *_migrateDriver) watch(ctx context.Context) func() {
+	self.mutex.Lock()
+	self.ctx = ctx
+	self.mutex.Unlock()
+
+	self.version.Store(int64(database.NilVersion))
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+
+	if self.warnPeriod <= 0 {
+		close(stopped)
+	} else {
+		go self.warn(ctx, stop, stopped)
+	}
+
+	return func() {
+		close(stop)
+		<-stopped
+
+		self.mutex.Lock()
+		self.ctx = nil
+		self.mutex.Unlock()
+	}
+}
+
+func (self *_migrateDriver) warn(ctx context.Context, stop <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	start := time.Now()
+	ticker := time.NewTicker(self.warnPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			elapsed := time.Since(start).Round(time.Second)
+
+			version := self.version.Load()
+			if version == int64(database.NilVersion) {
+				self.observer.Warnf(ctx, "Migrations still running after %s", elapsed)
+			} else {
+				self.observer.Warnf(ctx, "Migrations still running after %s, migrating to version %d",
+					elapsed, version)
+			}
+		}
+	}
+}
+
+func (self *_migrateDriver) SetVersion(version int, dirty bool) error {
+	self.mutex.Lock()
+	ctx := self.ctx
+	self.mutex.Unlock()
+
+	// golang-migrate marks the schema version as dirty right before running each migration,
+	// which is the safe point to stop at without leaving the schema version dirty
+	if dirty && ctx != nil && ctx.Err() != nil {
+		self.observer.Warnf(ctx, "Stopping migrations before version %d: %v", version, ctx.Err())
+		return ctx.Err()
+	}
+
+	if dirty && ctx != nil && self.beforeEach != nil {
+		err := self.beforeEach(ctx, _migrateVersion(version))
+		if err != nil {
+			self.observer.Warnf(ctx, "Stopping migrations before version %d: %v", version, err)
+			return err
+		}
+	}
+
+	if dirty {
+		self.version.Store(int64(version))
+
+		self.mutex.Lock()
+		self.running = true
+		self.started = time.Now()
+		self.mutex.Unlock()
+	}
+
+	err := self.Driver.SetVersion(version, dirty)
+
+	// golang-migrate marks the schema version as clean right after running each migration successfully
+	if !dirty || err != nil {
+		self.finish(version, err)
+	}
+
+	return err
+}
+
+// finish logs the duration and calls the after each hook of the running migration, if any.
+func (self *_migrateDriver) finish(version int, err error) {
+	self.mutex.Lock()
+	ctx, running, elapsed := self.ctx, self.running, time.Since(self.started).Round(time.Millisecond)
+	self.running = false
+	self.mutex.Unlock()
+
+	if !running {
+		return
+	}
+
+	if ctx != nil && self.afterEach != nil {
+		self.afterEach(ctx, _migrateVersion(version), err)
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if err != nil {
+		self.observer.Infof(ctx, "Migration to version %d failed in %s", version, elapsed)
+	} else {
+		self.observer.Infof(ctx, "Migrated to version %d in %s", version, elapsed)
+	}
+}
+
+// _migrateVersion converts the golang-migrate version, where no version is -1, to a schema version.
+func _migrateVersion(version int) uint {
+	if version < 0 {
+		return 0
+	}
+
+	return uint(version)
 }
@@ -2,30 +2,176 @@ package kit
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
 	"html/template"
 	"io"
 	"io/fs"
 	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"sort"
+	"sync"
+	texttemplate "text/template"
+	"text/template/parse"
+	"time"
 
+	"github.com/cockroachdb/errors"
 	"github.com/labstack/echo/v4"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/scylladb/go-set/strset"
+	"github.com/yuin/goldmark"
+)
+
+const (
+	_RENDERER_MIME_TEXT_MARKDOWN_CHARSET_UTF8 = "text/markdown; charset=UTF-8"
+	_RENDERER_ICON_EXTENSION                  = ".svg"
+	_RENDERER_MARKDOWN_EXTENSION              = ".md"
+	_RENDERER_NONCE_LENGTH                    = 24
+	_RENDERER_ICON_PLACEHOLDER                = `<svg xmlns="http://www.w3.org/2000/svg" width="16" height="16" viewBox="0 0 16 16"><title>missing icon %s</title><rect width="16" height="16" fill="#ff00ff"/></svg>` // nolint
+
+	_RENDERER_SLOT_PLACEHOLDER = `<div id="kit-slot-%s" hidden></div>`
+	_RENDERER_SLOT_CONTENT     = `<template id="kit-slot-content-%s">%s</template><script>(function(){var c=document.getElementById("kit-slot-content-%s"),p=document.getElementById("kit-slot-%s");if(c&&p){p.replaceWith(c.content);c.remove()}})()</script>` // nolint
 )
 
 var (
 	_RENDERER_DEFAULT_TEMPLATES_PATH      = "./templates"
 	_RENDERER_DEFAULT_TEMPLATE_EXTENSIONS = regexp.MustCompile(`^.*\.(html|txt|md)$`)
+	_RENDERER_DEFAULT_ICONS_PATH          = "./icons"
+	_RENDERER_CONTENT_TYPES               = map[string]string{
+		".html": echo.MIMETextHTMLCharsetUTF8,
+		".txt":  echo.MIMETextPlainCharsetUTF8,
+		".md":   _RENDERER_MIME_TEXT_MARKDOWN_CHARSET_UTF8,
+	}
+)
+
+type RendererDiffFormat string
+
+// Builtin diff formats.
+var (
+	RendererDiffUnified    RendererDiffFormat = "unified"
+	RendererDiffSideBySide RendererDiffFormat = "side-by-side"
 )
 
 type RendererConfig struct {
 	TemplatesPath      *string
 	TemplateExtensions *regexp.Regexp
+	// TemplatesFS is the source of the templates instead of TemplatesPath when set, e.g. an embed.FS
+	// of the templates bundled in the binary. The override paths are still read from disk.
+	TemplatesFS fs.FS
+	// RawTemplates lists the names of the templates that are parsed with text/template instead
+	// of html/template, so their output is NOT escaped. The caller is responsible for making sure
+	// that these templates and the data they render are trusted. Raw templates live in their own
+	// namespace, so they can only reference other raw templates.
+	RawTemplates []string
+	// IconsPath is the directory of the SVG icons inlined by the icon template function.
+	IconsPath *string
+	// OverridePaths are template directories layered in order on top of TemplatesPath,
+	// where later directories override the same-named templates of earlier ones.
+	// Override directories that do not exist are skipped.
+	OverridePaths []string
+	// Helpers are objects, by prefix, whose exported methods are registered as template
+	// functions before parsing the templates (see RegisterHelpers).
+	Helpers map[string]any
+	// Funcs are registered as template functions before parsing the templates (see AddFunc),
+	// which take precedence over the helpers.
+	Funcs template.FuncMap
+	// FallbackResolver is consulted with the name of a missing template to produce an
+	// alternative name to render instead, e.g. a base template for a tenant-specific one.
+	FallbackResolver func(name string) string
+	// RequireTemplates makes NewRenderer fail when no templates are found, e.g. because of a wrong
+	// templates path, instead of failing every render later on.
+	RequireTemplates bool
+	// Lazy only indexes the template files at construction, parsing each template, along with the
+	// ones it invokes, on its first render. Templates defined within other files cannot be invoked,
+	// and variants are unsupported.
+	Lazy bool
+	// MarkdownSanitizer sanitizes the HTML converted by RenderMarkdown when set, e.g. with the
+	// Sanitize of a bluemonday policy, on top of omitting the raw HTML and dangerous links.
+	MarkdownSanitizer func(html string) string
+	// Markdown converts the output of the .md templates to HTML, as RenderMarkdown does, on every render
+	// instead of rendering them as is. The .md templates are parsed as raw templates, so their markdown
+	// is not escaped before the conversion and they can only reference other raw templates.
+	Markdown bool
+	// AssetManifest maps the asset names to their fingerprinted paths resolved by the asset template
+	// function, e.g. {{ asset "app.css" }} to /static/app.abc123.css.
+	AssetManifest map[string]string
+	// AssetManifestPath is a JSON file with the same mapping as AssetManifest, which takes
+	// precedence over the entries of the file.
+	AssetManifestPath *string
+	// MetricsRegisterer registers the histograms of the render durations and output sizes,
+	// labeled by template, when set.
+	MetricsRegisterer prometheus.Registerer
+	// DiffFormat is the format of the HTML diffs of RenderDiff, unified by default.
+	DiffFormat *RendererDiffFormat
+}
+
+type RendererComplexityReport struct {
+	// Nodes is the number of nodes of the parse tree.
+	Nodes int
+	// Depth is the maximum nesting depth of the control structures (if, range and with).
+	Depth  int
+	Ranges int
+	Withs  int
+}
+
+type RendererCachePolicy struct {
+	MaxAge  time.Duration
+	Private bool
+}
+
+// CacheControl returns the Cache-Control header value of the policy.
+func (self RendererCachePolicy) CacheControl() string {
+	visibility := "public"
+	if self.Private {
+		visibility = "private"
+	}
+
+	return fmt.Sprintf("%s, max-age=%d", visibility, int(self.MaxAge.Seconds()))
+}
+
+type RendererSlot struct {
+	// Name is the name of the slot declared in the page with {{ defer "name" }}.
+	Name     string
+	Template string
+	Data     any
 }
 
 type Renderer struct {
-	config   RendererConfig
-	observer Observer
-	renderer *template.Template
+	config      RendererConfig
+	observer    Observer
+	base        *template.Template
+	renderer    *template.Template
+	rawRenderer *texttemplate.Template
+	rawNames    *strset.Set
+	icons       *sync.Map
+	helpers     template.FuncMap
+	policies    *sync.Map
+	paths       map[string]_rendererFile
+	lazy        *sync.Map
+	markdowns   *sync.Map
+	assets      map[string]string
+	durations   *prometheus.HistogramVec
+	sizes       *prometheus.HistogramVec
+	mutex       *sync.RWMutex
+}
+
+type _rendererFile struct {
+	fsys fs.FS
+	path string
+}
+
+type _rendererTemplates struct {
+	base        *template.Template
+	renderer    *template.Template
+	rawRenderer *texttemplate.Template
 }
 
 func NewRenderer(observer Observer, config RendererConfig) (*Renderer, error) {
@@ -37,50 +183,456 @@ func NewRenderer(observer Observer, config RendererConfig) (*Renderer, error) {
 		config.TemplateExtensions = _RENDERER_DEFAULT_TEMPLATE_EXTENSIONS.Copy()
 	}
 
+	if config.IconsPath == nil {
+		config.IconsPath = ptr(_RENDERER_DEFAULT_ICONS_PATH)
+	}
+
+	if config.DiffFormat == nil {
+		config.DiffFormat = ptr(RendererDiffUnified)
+	}
+
 	*config.TemplatesPath = filepath.Clean(*config.TemplatesPath)
+	*config.IconsPath = filepath.Clean(*config.IconsPath)
+
+	for i := range config.OverridePaths {
+		config.OverridePaths[i] = filepath.Clean(config.OverridePaths[i])
+	}
 
-	renderer := template.New("")
+	renderer := &Renderer{
+		config:   config,
+		observer: observer,
+		rawNames: strset.New(config.RawTemplates...),
+		icons:    &sync.Map{},
+		helpers:  template.FuncMap{},
+		policies: &sync.Map{},
+		assets:   map[string]string{},
+		mutex:    &sync.RWMutex{},
+	}
 
-	err := filepath.WalkDir(*config.TemplatesPath, func(path string, info fs.DirEntry, err error) error {
+	if config.AssetManifestPath != nil {
+		file, err := ioutil.ReadFile(*config.AssetManifestPath)
 		if err != nil {
-			return ErrRendererGeneric().WrapAs(err)
+			return nil, ErrRendererGeneric().Wrap(err)
 		}
 
-		if info.IsDir() {
-			return nil
+		err = json.Unmarshal(file, &renderer.assets)
+		if err != nil {
+			return nil, ErrRendererGeneric().Wrap(err)
 		}
+	}
 
-		if !config.TemplateExtensions.MatchString(info.Name()) {
-			return nil
+	for name, path := range config.AssetManifest {
+		renderer.assets[name] = path
+	}
+
+	if config.MetricsRegisterer != nil {
+		renderer.durations = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "kit_renderer_render_duration_seconds",
+			Help:    "Duration of the template renders.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"template"})
+
+		renderer.sizes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "kit_renderer_render_size_bytes",
+			Help:    "Output size of the template renders.",
+			Buckets: prometheus.ExponentialBuckets(256, 4, 8),
+		}, []string{"template"})
+
+		for _, collector := range []prometheus.Collector{renderer.durations, renderer.sizes} {
+			err := config.MetricsRegisterer.Register(collector)
+			if err != nil {
+				return nil, ErrRendererGeneric().Wrap(err)
+			}
+		}
+	}
+
+	for prefix, obj := range config.Helpers {
+		helpers, err := _getHelpers(prefix, obj)
+		if err != nil {
+			return nil, ErrRendererGeneric().Wrap(err)
 		}
 
-		name := path[len(*config.TemplatesPath)+1:]
+		for name, helper := range helpers {
+			renderer.helpers[name] = helper
+		}
+	}
 
-		file, err := ioutil.ReadFile(path)
+	for name, fn := range config.Funcs {
+		err := _checkFunc(name, fn)
 		if err != nil {
-			return ErrRendererGeneric().WrapAs(err)
+			return nil, ErrRendererGeneric().Wrap(err)
 		}
 
-		_, err = renderer.New(name).Parse(string(file))
+		renderer.helpers[name] = fn
+	}
+
+	err := renderer.parse()
+	if err != nil {
+		return nil, ErrRendererGeneric().Wrap(err)
+	}
+
+	return renderer, nil
+}
+
+// parse parses the templates, or only indexes them when lazy.
+func (self *Renderer) parse() error {
+	if self.config.Lazy {
+		paths := make(map[string]_rendererFile)
+
+		err := self.walk(func(name string, file _rendererFile) error {
+			paths[name] = file
+			return nil
+		})
 		if err != nil {
-			return ErrRendererGeneric().WrapAs(err)
+			return ErrRendererGeneric().Wrap(err)
+		}
+
+		if self.config.RequireTemplates && len(paths) < 1 {
+			return ErrRendererGeneric().Withf("no templates found in %s", *self.config.TemplatesPath)
 		}
 
+		funcs := self.funcs()
+
+		self.mutex.Lock()
+		self.paths = paths
+		self.lazy = &sync.Map{}
+		self.markdowns = &sync.Map{}
+		self.base = template.New("").Funcs(funcs)
+		self.renderer = template.New("").Funcs(funcs)
+		self.rawRenderer = texttemplate.New("").Funcs(texttemplate.FuncMap(funcs))
+		self.mutex.Unlock()
+
 		return nil
+	}
+
+	templates, rawTemplates, paths, err := self.load()
+	if err != nil {
+		return ErrRendererGeneric().Wrap(err)
+	}
+
+	if self.config.RequireTemplates {
+		// The unnamed root templates are not templates of the files
+		found := 0
+
+		for _, tmpl := range templates.Templates() {
+			if tmpl.Name() != "" {
+				found++
+			}
+		}
+
+		for _, tmpl := range rawTemplates.Templates() {
+			if tmpl.Name() != "" {
+				found++
+			}
+		}
+
+		if found < 1 {
+			return ErrRendererGeneric().Withf("no templates found in %s", *self.config.TemplatesPath)
+		}
+	}
+
+	// HTML templates cannot be cloned once executed, so an unexecuted base is kept for variants
+	renderer, err := templates.Clone()
+	if err != nil {
+		return ErrRendererGeneric().Wrap(err)
+	}
+
+	self.mutex.Lock()
+	self.paths = paths
+	self.markdowns = &sync.Map{}
+	self.base = templates
+	self.renderer = renderer
+	self.rawRenderer = rawTemplates
+	self.mutex.Unlock()
+
+	return nil
+}
+
+// Refresh reads and parses the templates again from their source, swapping them in place only when
+// every template parses, so a broken template keeps the previous ones rendering. Icons are read again too.
+func (self *Renderer) Refresh() error {
+	err := self.parse()
+	if err != nil {
+		return ErrRendererGeneric().Wrap(err)
+	}
+
+	self.icons.Range(func(key any, _ any) bool {
+		self.icons.Delete(key)
+		return true
+	})
+
+	self.observer.Info(context.Background(), "Reloaded templates")
+
+	return nil
+}
+
+func (self *Renderer) load() (*template.Template, *texttemplate.Template, map[string]_rendererFile, error) {
+	funcs := self.funcs()
+
+	templates := template.New("").Funcs(funcs)
+	rawTemplates := texttemplate.New("").Funcs(texttemplate.FuncMap(funcs))
+	paths := make(map[string]_rendererFile)
+
+	err := self.walk(func(name string, file _rendererFile) error {
+		paths[name] = file
+		return self.parseFile(templates, rawTemplates, name, file)
+	})
+	if err != nil {
+		return nil, nil, nil, ErrRendererGeneric().Wrap(err)
+	}
+
+	return templates, rawTemplates, paths, nil
+}
+
+// walk walks the template files of the templates path and then of the override paths in order.
+func (self *Renderer) walk(fn func(name string, file _rendererFile) error) error {
+	paths := append([]string{*self.config.TemplatesPath}, self.config.OverridePaths...)
+
+	for i, root := range paths {
+		var fsys fs.FS
+
+		switch {
+		case i == 0 && self.config.TemplatesFS != nil:
+			fsys = self.config.TemplatesFS
+		case i > 0:
+			if _, err := os.Stat(root); errors.Is(err, fs.ErrNotExist) {
+				self.observer.Debugf(context.Background(), "Skipping missing templates override path %s", root)
+				continue
+			}
+
+			fallthrough
+		default:
+			fsys = os.DirFS(root)
+		}
+
+		err := fs.WalkDir(fsys, ".", func(path string, info fs.DirEntry, err error) error {
+			if err != nil {
+				return ErrRendererGeneric().WrapAs(err)
+			}
+
+			if info.IsDir() {
+				return nil
+			}
+
+			if !self.config.TemplateExtensions.MatchString(info.Name()) {
+				return nil
+			}
+
+			// Named with the separators of the OS paths, as when walking the disk
+			return fn(filepath.FromSlash(path), _rendererFile{fsys: fsys, path: path})
+		})
+		if err != nil {
+			return ErrRendererGeneric().Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+func (self *Renderer) parseFile(templates *template.Template, rawTemplates *texttemplate.Template,
+	name string, source _rendererFile) error {
+	file, err := fs.ReadFile(source.fsys, source.path)
+	if err != nil {
+		return ErrRendererGeneric().WrapAs(err)
+	}
+
+	if self.raw(name) {
+		_, err = rawTemplates.New(name).Parse(string(file))
+	} else {
+		_, err = templates.New(name).Parse(string(file))
+	}
+	if err != nil {
+		return ErrRendererGeneric().WrapAs(err)
+	}
+
+	return nil
+}
+
+// lookup returns the templates to render the named template with, which are the ones of the
+// renderer unless lazy, where the template is parsed on its first render along with the templates
+// it invokes, or its fallback when missing.
+func (self *Renderer) lookup(name string) (*_rendererTemplates, error) {
+	self.mutex.RLock()
+	eager := &_rendererTemplates{base: self.base, renderer: self.renderer, rawRenderer: self.rawRenderer}
+	paths, lazy := self.paths, self.lazy
+	self.mutex.RUnlock()
+
+	if !self.config.Lazy {
+		return eager, nil
+	}
+
+	if _, ok := paths[name]; !ok && self.config.FallbackResolver != nil {
+		name = self.config.FallbackResolver(name)
+	}
+
+	if templates, ok := lazy.Load(name); ok {
+		return templates.(*_rendererTemplates), nil
+	}
+
+	funcs := self.funcs()
+
+	templates := template.New("").Funcs(funcs)
+	rawTemplates := texttemplate.New("").Funcs(texttemplate.FuncMap(funcs))
+
+	parsed := strset.New()
+	pending := []string{name}
+
+	for len(pending) > 0 {
+		current := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		file, ok := paths[current]
+		if !ok || parsed.Has(current) {
+			continue
+		}
+
+		err := self.parseFile(templates, rawTemplates, current, file)
+		if err != nil {
+			return nil, ErrRendererGeneric().Wrap(err)
+		}
+
+		parsed.Add(current)
+
+		var trees []*parse.Tree
+
+		for _, tmpl := range templates.Templates() {
+			trees = append(trees, tmpl.Tree)
+		}
+
+		for _, tmpl := range rawTemplates.Templates() {
+			trees = append(trees, tmpl.Tree)
+		}
+
+		for _, tree := range trees {
+			if tree != nil {
+				pending = append(pending, _templateReferences(tree.Root)...)
+			}
+		}
+	}
+
+	renderer, err := templates.Clone()
+	if err != nil {
+		return nil, ErrRendererGeneric().Wrap(err)
+	}
+
+	loaded, _ := lazy.LoadOrStore(name, &_rendererTemplates{
+		base:        templates,
+		renderer:    renderer,
+		rawRenderer: rawTemplates,
 	})
+
+	return loaded.(*_rendererTemplates), nil
+}
+
+// _templateReferences returns the names of the templates invoked within the node.
+func _templateReferences(node parse.Node) []string {
+	if node == nil || reflect.ValueOf(node).IsNil() {
+		return nil
+	}
+
+	switch node := node.(type) {
+	case *parse.ListNode:
+		var references []string
+
+		for _, child := range node.Nodes {
+			references = append(references, _templateReferences(child)...)
+		}
+
+		return references
+	case *parse.TemplateNode:
+		return []string{node.Name}
+	case *parse.IfNode:
+		return append(_templateReferences(node.List), _templateReferences(node.ElseList)...)
+	case *parse.RangeNode:
+		return append(_templateReferences(node.List), _templateReferences(node.ElseList)...)
+	case *parse.WithNode:
+		return append(_templateReferences(node.List), _templateReferences(node.ElseList)...)
+	}
+
+	return nil
+}
+
+// Variant returns a lightweight renderer sharing every template with this one except
+// the named template, which is overridden with the given content. This renderer is unmodified.
+func (self *Renderer) Variant(name string, content string) (*Renderer, error) {
+	if self.config.Lazy {
+		return nil, ErrRendererGeneric().With("variants are not supported by lazy renderers")
+	}
+
+	self.mutex.RLock()
+	variant := *self
+	self.mutex.RUnlock()
+
+	if self.raw(name) {
+		rawTemplates, err := variant.rawRenderer.Clone()
+		if err != nil {
+			return nil, ErrRendererGeneric().Wrap(err)
+		}
+
+		_, err = rawTemplates.New(name).Parse(content)
+		if err != nil {
+			return nil, ErrRendererGeneric().Wrap(err)
+		}
+
+		variant.rawRenderer = rawTemplates
+
+		return &variant, nil
+	}
+
+	templates, err := variant.base.Clone()
 	if err != nil {
 		return nil, ErrRendererGeneric().Wrap(err)
 	}
 
-	return &Renderer{
-		config:   config,
-		observer: observer,
-		renderer: renderer,
-	}, nil
+	_, err = templates.New(name).Parse(content)
+	if err != nil {
+		return nil, ErrRendererGeneric().Wrap(err)
+	}
+
+	variant.base = templates
+
+	variant.renderer, err = templates.Clone()
+	if err != nil {
+		return nil, ErrRendererGeneric().Wrap(err)
+	}
+
+	return &variant, nil
 }
 
-func (self *Renderer) Render(w io.Writer, name string, data any, c echo.Context) error { // nolint
-	err := self.renderer.ExecuteTemplate(w, name, data)
+func (self *Renderer) funcs() template.FuncMap {
+	funcs := template.FuncMap{
+		"icon":  self.icon,
+		"defer": self.slot,
+		"nonce": self.nonce,
+		"asset": self.asset,
+	}
+
+	self.mutex.RLock()
+	for name, helper := range self.helpers {
+		funcs[name] = helper
+	}
+	self.mutex.RUnlock()
+
+	return funcs
+}
+
+// RegisterHelpers registers every exported method of the object as the template function
+// prefix_MethodName, e.g. {{ money_Format .Price }}. Methods must return a single value or a
+// value and an error, as required by the template packages. As template functions are resolved
+// at parse time, the templates are parsed again, so it must be called before rendering.
+func (self *Renderer) RegisterHelpers(prefix string, obj any) error {
+	helpers, err := _getHelpers(prefix, obj)
+	if err != nil {
+		return ErrRendererGeneric().Wrap(err)
+	}
+
+	self.mutex.Lock()
+	for name, helper := range helpers {
+		self.helpers[name] = helper
+	}
+	self.mutex.Unlock()
+
+	err = self.parse()
 	if err != nil {
 		return ErrRendererGeneric().Wrap(err)
 	}
@@ -88,8 +640,20 @@ func (self *Renderer) Render(w io.Writer, name string, data any, c echo.Context)
 	return nil
 }
 
-func (self *Renderer) RenderWriter(w io.Writer, template string, data any) error { // nolint
-	err := self.renderer.ExecuteTemplate(w, template, data)
+// AddFunc registers the function as the named template function, such as {{ upper .Name }}.
+// As template functions are resolved at parse time, the templates are parsed again,
+// so it must be called before rendering.
+func (self *Renderer) AddFunc(name string, fn any) error {
+	err := _checkFunc(name, fn)
+	if err != nil {
+		return ErrRendererGeneric().Wrap(err)
+	}
+
+	self.mutex.Lock()
+	self.helpers[name] = fn
+	self.mutex.Unlock()
+
+	err = self.parse()
 	if err != nil {
 		return ErrRendererGeneric().Wrap(err)
 	}
@@ -97,22 +661,830 @@ func (self *Renderer) RenderWriter(w io.Writer, template string, data any) error
 	return nil
 }
 
-func (self *Renderer) RenderBytes(template string, data any) ([]byte, error) { // nolint
-	var w bytes.Buffer
+// _checkFunc checks the template function, as the template packages panic on the invalid ones.
+func _checkFunc(name string, fn any) error {
+	value := reflect.ValueOf(fn)
+	errorType := reflect.TypeOf((*error)(nil)).Elem()
 
-	err := self.RenderWriter(&w, template, data)
+	if value.Kind() != reflect.Func {
+		return ErrRendererGeneric().Withf("function %s is not a function", name)
+	}
+
+	outs := value.Type().NumOut()
+	if outs < 1 || outs > 2 || (outs == 2 && value.Type().Out(1) != errorType) {
+		return ErrRendererGeneric().Withf("function %s must return a value or a value and an error", name)
+	}
+
+	return nil
+}
+
+func _getHelpers(prefix string, obj any) (template.FuncMap, error) {
+	value := reflect.ValueOf(obj)
+	errorType := reflect.TypeOf((*error)(nil)).Elem()
+
+	if !value.IsValid() || (value.Kind() == reflect.Ptr && value.IsNil()) {
+		return nil, ErrRendererGeneric().Withf("helpers %s are nil", prefix)
+	}
+
+	helpers := template.FuncMap{}
+
+	for i := 0; i < value.NumMethod(); i++ {
+		name := prefix + "_" + value.Type().Method(i).Name
+		method := value.Method(i)
+
+		outs := method.Type().NumOut()
+		if outs < 1 || outs > 2 || (outs == 2 && method.Type().Out(1) != errorType) {
+			return nil, ErrRendererGeneric().Withf("helper %s must return a value or a value and an error", name)
+		}
+
+		helpers[name] = method.Interface()
+	}
+
+	return helpers, nil
+}
+
+// icon inlines the named SVG icon, rendering a visible placeholder when it is missing.
+func (self *Renderer) icon(name string) template.HTML { // nolint
+	if icon, ok := self.icons.Load(name); ok {
+		return icon.(template.HTML)
+	}
+
+	path := filepath.Join(*self.config.IconsPath, filepath.Clean("/"+name)+_RENDERER_ICON_EXTENSION)
+
+	file, err := ioutil.ReadFile(path)
 	if err != nil {
-		return nil, ErrRendererGeneric().Wrap(err)
+		self.observer.Warnf(context.Background(), "Cannot inline icon %s: %v", name, err)
+		return template.HTML(fmt.Sprintf(_RENDERER_ICON_PLACEHOLDER, html.EscapeString(name))) // nolint
 	}
 
-	return w.Bytes(), nil
+	icon := template.HTML(file) // nolint
+	self.icons.Store(name, icon)
+
+	return icon
 }
 
-func (self *Renderer) RenderString(template string, data any) (string, error) { // nolint
-	bytes, err := self.RenderBytes(template, data) // nolint
+// asset resolves the fingerprinted path of the named asset, which is returned as is when missing.
+func (self *Renderer) asset(name string) string {
+	if path, ok := self.assets[name]; ok {
+		return path
+	}
+
+	self.observer.Warnf(context.Background(), "Asset %s not found in the manifest", name)
+
+	return name
+}
+
+func (self *Renderer) execute(w io.Writer, name string, data any) error {
+	templates, err := self.lookup(name)
 	if err != nil {
-		return "", ErrRendererGeneric().Wrap(err)
+		return err
 	}
 
-	return string(bytes), nil
+	return self.executeIn(templates.renderer, templates.rawRenderer, w, name, data)
+}
+
+func (self *Renderer) executeIn(templates *template.Template, rawTemplates *texttemplate.Template,
+	w io.Writer, name string, data any) error {
+	if !self.defined(templates, rawTemplates, name) {
+		if self.config.FallbackResolver == nil {
+			return ErrRendererTemplateNotFound().Withf("template %s", name)
+		}
+
+		fallback := self.config.FallbackResolver(name)
+		if !self.defined(templates, rawTemplates, fallback) {
+			return ErrRendererTemplateNotFound().Withf("template %s nor fallback %s", name, fallback)
+		}
+
+		name = fallback
+	}
+
+	if self.durations != nil {
+		start := time.Now()
+		counter := &_rendererCounter{writer: w}
+		w = counter
+
+		defer func() {
+			self.durations.WithLabelValues(name).Observe(time.Since(start).Seconds())
+			self.sizes.WithLabelValues(name).Observe(float64(counter.size))
+		}()
+	}
+
+	if !self.config.Markdown || filepath.Ext(name) != _RENDERER_MARKDOWN_EXTENSION {
+		return self.executeTemplate(templates, rawTemplates, w, name, data)
+	}
+
+	var source bytes.Buffer
+
+	err := self.executeTemplate(templates, rawTemplates, &source, name, data)
+	if err != nil {
+		return err
+	}
+
+	output, err := self.markdown(source.Bytes())
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, output)
+
+	return err
+}
+
+func (self *Renderer) executeTemplate(templates *template.Template, rawTemplates *texttemplate.Template,
+	w io.Writer, name string, data any) error {
+	if self.raw(name) {
+		return rawTemplates.ExecuteTemplate(w, name, data)
+	}
+
+	return templates.ExecuteTemplate(w, name, data)
+}
+
+// _rendererCounter counts the bytes written to the writer.
+type _rendererCounter struct {
+	writer io.Writer
+	size   int
+}
+
+func (self *_rendererCounter) Write(p []byte) (int, error) {
+	n, err := self.writer.Write(p)
+	self.size += n
+
+	return n, err
+}
+
+// raw reports whether the template is parsed with text/template, as the RawTemplates
+// and the .md templates with Markdown set.
+func (self *Renderer) raw(name string) bool {
+	return self.rawNames.Has(name) || (self.config.Markdown && filepath.Ext(name) == _RENDERER_MARKDOWN_EXTENSION)
+}
+
+func (self *Renderer) defined(templates *template.Template, rawTemplates *texttemplate.Template, name string) bool {
+	if self.raw(name) {
+		return rawTemplates.Lookup(name) != nil
+	}
+
+	return templates.Lookup(name) != nil
+}
+
+func (self *Renderer) Render(w io.Writer, name string, data any, c echo.Context) error { // nolint
+	err := self.execute(w, name, data)
+	switch {
+	case err == nil:
+	case ErrRendererTemplateNotFound().Is(err):
+		return err
+	default:
+		return ErrRendererGeneric().Wrap(err)
+	}
+
+	return nil
+}
+
+func (self *Renderer) RenderWriter(w io.Writer, template string, data any) error { // nolint
+	err := self.execute(w, template, data)
+	switch {
+	case err == nil:
+	case ErrRendererTemplateNotFound().Is(err):
+		return err
+	default:
+		return ErrRendererGeneric().Wrap(err)
+	}
+
+	return nil
+}
+
+func (self *Renderer) RenderBytes(template string, data any) ([]byte, error) { // nolint
+	var w bytes.Buffer
+
+	err := self.RenderWriter(&w, template, data)
+	switch {
+	case err == nil:
+	case ErrRendererTemplateNotFound().Is(err):
+		return nil, err
+	default:
+		return nil, ErrRendererGeneric().Wrap(err)
+	}
+
+	return w.Bytes(), nil
+}
+
+func (self *Renderer) RenderString(template string, data any) (string, error) { // nolint
+	bytes, err := self.RenderBytes(template, data) // nolint
+	switch {
+	case err == nil:
+	case ErrRendererTemplateNotFound().Is(err):
+		return "", err
+	default:
+		return "", ErrRendererGeneric().Wrap(err)
+	}
+
+	return string(bytes), nil
+}
+
+// RenderResponse renders the template into a buffer and, only if it succeeds, writes it as the
+// response with the given status and the content type derived from the template extension.
+func (self *Renderer) RenderResponse(c echo.Context, status int, name string, data any) error {
+	bytes, err := self.RenderBytes(name, data) // nolint
+	switch {
+	case err == nil:
+	case ErrRendererTemplateNotFound().Is(err):
+		return err
+	default:
+		return ErrRendererGeneric().Wrap(err)
+	}
+
+	extension := filepath.Ext(name)
+
+	contentType, ok := _RENDERER_CONTENT_TYPES[extension]
+	if self.config.Markdown && extension == _RENDERER_MARKDOWN_EXTENSION {
+		contentType = echo.MIMETextHTMLCharsetUTF8
+	}
+	if !ok {
+		contentType = mime.TypeByExtension(extension)
+		if contentType == "" {
+			contentType = echo.MIMEOctetStream
+		}
+	}
+
+	err = c.Blob(status, contentType, bytes)
+	if err != nil {
+		return ErrRendererGeneric().Wrap(err)
+	}
+
+	return nil
+}
+
+// WarmUp executes every template once against its sample data, or nil when missing, discarding
+// the output, to force the lazy escaping analysis of html/template before the first request.
+// Every template is attempted and the failures are logged and returned combined, so the caller
+// decides whether they are fatal (executions failing because of the sample data still warm up).
+func (self *Renderer) WarmUp(sampleData map[string]any) error {
+	var errs error
+
+	self.mutex.RLock()
+	paths, renderer, rawRenderer := self.paths, self.renderer, self.rawRenderer
+	self.mutex.RUnlock()
+
+	if self.config.Lazy {
+		names := make([]string, 0, len(paths))
+		for name := range paths {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			err := self.execute(io.Discard, name, sampleData[name])
+			if err != nil {
+				self.observer.Warnf(context.Background(), "Cannot warm up template %s: %v", name, err)
+				errs = Utils.CombineErrors(errs, err)
+			}
+		}
+	}
+
+	for _, tmpl := range renderer.Templates() {
+		if tmpl.Name() == "" {
+			continue
+		}
+
+		err := tmpl.Execute(io.Discard, sampleData[tmpl.Name()])
+		if err != nil {
+			self.observer.Warnf(context.Background(), "Cannot warm up template %s: %v", tmpl.Name(), err)
+			errs = Utils.CombineErrors(errs, err)
+		}
+	}
+
+	for _, tmpl := range rawRenderer.Templates() {
+		if tmpl.Name() == "" {
+			continue
+		}
+
+		err := tmpl.Execute(io.Discard, sampleData[tmpl.Name()])
+		if err != nil {
+			self.observer.Warnf(context.Background(), "Cannot warm up template %s: %v", tmpl.Name(), err)
+			errs = Utils.CombineErrors(errs, err)
+		}
+	}
+
+	if errs != nil {
+		return ErrRendererGeneric().Wrap(errs)
+	}
+
+	return nil
+}
+
+// RenderFresh reads and parses the templates again from their source and renders the named
+// template, without touching the parsed templates used by the other render methods.
+func (self *Renderer) RenderFresh(name string, data any) ([]byte, error) {
+	templates, rawTemplates, _, err := self.load()
+	if err != nil {
+		return nil, ErrRendererGeneric().Wrap(err)
+	}
+
+	var w bytes.Buffer
+
+	err = self.executeIn(templates, rawTemplates, &w, name, data)
+	switch {
+	case err == nil:
+	case ErrRendererTemplateNotFound().Is(err):
+		return nil, err
+	default:
+		return nil, ErrRendererGeneric().Wrap(err)
+	}
+
+	return w.Bytes(), nil
+}
+
+// RenderWithLayout renders the layout with the blocks defined by the page, such as a base.html
+// with a {{ block "content" . }} filled in by the page. As the blocks of every template share the
+// same namespace, the page and the layouts it inherits from are parsed again from their files,
+// root layout first, so their blocks override the same-named ones of the other templates.
+func (self *Renderer) RenderWithLayout(w io.Writer, layout string, page string, data any) error {
+	self.mutex.RLock()
+	paths := self.paths
+	self.mutex.RUnlock()
+
+	for _, name := range []string{layout, page} {
+		if _, ok := paths[name]; !ok {
+			return ErrRendererTemplateNotFound().Withf("template %s", name)
+		}
+
+		if self.raw(name) {
+			return ErrRendererGeneric().Withf("layout template %s is a raw template", name)
+		}
+	}
+
+	templates, err := self.lookup(layout)
+	if err != nil {
+		return ErrRendererGeneric().Wrap(err)
+	}
+
+	inherited, err := templates.base.Clone()
+	if err != nil {
+		return ErrRendererGeneric().Wrap(err)
+	}
+
+	parsed := strset.New()
+
+	var inherit func(name string) error
+	inherit = func(name string) error {
+		file, ok := paths[name]
+		if !ok || self.raw(name) || parsed.Has(name) {
+			return nil
+		}
+
+		parsed.Add(name)
+
+		// The template is parsed first when missing, as in lazy renderers, to find the ones it invokes
+		if inherited.Lookup(name) == nil {
+			err := self.parseFile(inherited, nil, name, file)
+			if err != nil {
+				return err
+			}
+		}
+
+		if tree := inherited.Lookup(name).Tree; tree != nil {
+			for _, reference := range _templateReferences(tree.Root) {
+				err := inherit(reference)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return self.parseFile(inherited, nil, name, file)
+	}
+
+	err = inherit(layout)
+	if err != nil {
+		return ErrRendererGeneric().Wrap(err)
+	}
+
+	err = inherit(page)
+	if err != nil {
+		return ErrRendererGeneric().Wrap(err)
+	}
+
+	err = self.executeIn(inherited, templates.rawRenderer, w, layout, data)
+	switch {
+	case err == nil:
+	case ErrRendererTemplateNotFound().Is(err):
+		return err
+	default:
+		return ErrRendererGeneric().Wrap(err)
+	}
+
+	return nil
+}
+
+// Complexity reports the static complexity of the named template from its parse tree,
+// without following the templates it invokes.
+func (self *Renderer) Complexity(name string) (RendererComplexityReport, error) {
+	templates, err := self.lookup(name)
+	if err != nil {
+		return RendererComplexityReport{}, err
+	}
+
+	var tree *parse.Tree
+
+	if self.raw(name) {
+		if found := templates.rawRenderer.Lookup(name); found != nil {
+			tree = found.Tree
+		}
+	} else if found := templates.base.Lookup(name); found != nil {
+		// The base is measured because executing a template augments its tree with the escapers
+		tree = found.Tree
+	}
+
+	if tree == nil || tree.Root == nil {
+		return RendererComplexityReport{}, ErrRendererTemplateNotFound().Withf("template %s", name)
+	}
+
+	report := RendererComplexityReport{}
+	_measureComplexity(&report, tree.Root, 0)
+
+	return report, nil
+}
+
+func _measureComplexity(report *RendererComplexityReport, node parse.Node, depth int) {
+	if node == nil || reflect.ValueOf(node).IsNil() {
+		return
+	}
+
+	report.Nodes++
+
+	var branch *parse.BranchNode
+
+	switch node := node.(type) {
+	case *parse.ListNode:
+		for _, child := range node.Nodes {
+			_measureComplexity(report, child, depth)
+		}
+	case *parse.ActionNode:
+		_measureComplexity(report, node.Pipe, depth)
+	case *parse.TemplateNode:
+		_measureComplexity(report, node.Pipe, depth)
+	case *parse.PipeNode:
+		for _, variable := range node.Decl {
+			_measureComplexity(report, variable, depth)
+		}
+
+		for _, command := range node.Cmds {
+			_measureComplexity(report, command, depth)
+		}
+	case *parse.CommandNode:
+		for _, arg := range node.Args {
+			_measureComplexity(report, arg, depth)
+		}
+	case *parse.ChainNode:
+		_measureComplexity(report, node.Node, depth)
+	case *parse.IfNode:
+		branch = &node.BranchNode
+	case *parse.RangeNode:
+		report.Ranges++
+		branch = &node.BranchNode
+	case *parse.WithNode:
+		report.Withs++
+		branch = &node.BranchNode
+	}
+
+	if branch != nil {
+		depth++
+		report.Depth = max(report.Depth, depth)
+
+		_measureComplexity(report, branch.Pipe, depth)
+		_measureComplexity(report, branch.List, depth)
+		_measureComplexity(report, branch.ElseList, depth)
+	}
+}
+
+// RegisterCachePolicy registers the HTTP cache policy of the named template,
+// so it is decided along with the template rather than by every handler.
+func (self *Renderer) RegisterCachePolicy(name string, maxAge time.Duration, private bool) {
+	self.policies.Store(name, RendererCachePolicy{MaxAge: maxAge, Private: private})
+}
+
+// CachePolicy returns the HTTP cache policy registered for the named template, if any.
+func (self *Renderer) CachePolicy(name string) (RendererCachePolicy, bool) {
+	policy, ok := self.policies.Load(name)
+	if !ok {
+		return RendererCachePolicy{}, false
+	}
+
+	return policy.(RendererCachePolicy), true
+}
+
+// RenderWithFallback renders the template within the budget, returning the fallback instead when
+// the render fails or exceeds the budget, for optional page sections. A render exceeding the budget
+// cannot be interrupted, so it keeps running in an orphaned goroutine until it finishes on its own.
+func (self *Renderer) RenderWithFallback(ctx context.Context, name string, data any,
+	budget time.Duration, fallback []byte) []byte {
+	ctx, cancel := context.WithTimeout(ctx, budget)
+	defer cancel()
+
+	var rendered []byte
+
+	err := Utils.Deadline(ctx, func(exceeded <-chan struct{}) error {
+		output, err := self.RenderBytes(name, data)
+		if err != nil {
+			return err
+		}
+
+		rendered = output
+
+		return nil
+	})
+	switch {
+	case err == nil:
+		return rendered
+	case ErrDeadlineExceeded().Is(err):
+		self.observer.Warnf(ctx, "Template %s exceeded its render budget of %s, using the fallback", name, budget)
+	default:
+		self.observer.Warnf(ctx, "Template %s failed to render, using the fallback: %v", name, err)
+	}
+
+	return fallback
+}
+
+func (self *Renderer) slot(name string) template.HTML {
+	return template.HTML(fmt.Sprintf(_RENDERER_SLOT_PLACEHOLDER, html.EscapeString(name))) // nolint
+}
+
+// RenderStream renders and flushes the page immediately, with placeholders for its {{ defer "name" }}
+// slots, and then streams and flushes the content of each slot as it is received, until the slots
+// channel is closed. Slots are swapped into their placeholders by an inline script, so it requires
+// JavaScript and the HTML template element in the browser, and no response buffering in between.
+func (self *Renderer) RenderStream(ctx context.Context, w io.Writer, name string, data any,
+	slots <-chan RendererSlot) error {
+	err := self.RenderWriter(w, name, data)
+	if err != nil {
+		return err
+	}
+
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ErrRendererGeneric().Wrap(ctx.Err())
+		case slot, ok := <-slots:
+			if !ok {
+				return nil
+			}
+
+			var content bytes.Buffer
+
+			err = self.execute(&content, slot.Template, slot.Data)
+			if err != nil {
+				return ErrRendererGeneric().Wrap(err)
+			}
+
+			id := html.EscapeString(slot.Name)
+			jsID := texttemplate.JSEscapeString(slot.Name)
+
+			_, err = fmt.Fprintf(w, _RENDERER_SLOT_CONTENT, id, content.String(), jsID, jsID)
+			if err != nil {
+				return ErrRendererGeneric().Wrap(err)
+			}
+
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func (self *Renderer) nonce() (string, error) {
+	return "", ErrRendererGeneric().With("nonce is only available when rendering with RenderWithNonce")
+}
+
+// RenderWithNonce renders the template with a fresh random nonce for its {{ nonce }} calls, returned
+// along with the output so the Content-Security-Policy header can be set with the same nonce. As the
+// nonce is bound per render, the templates are cloned every time, which is costlier than rendering.
+func (self *Renderer) RenderWithNonce(name string, data any) ([]byte, string, error) {
+	nonce := Utils.RandomString(_RENDERER_NONCE_LENGTH)
+	funcs := map[string]any{
+		"nonce": func() string { return nonce },
+	}
+
+	lookup, err := self.lookup(name)
+	if err != nil {
+		return nil, "", ErrRendererGeneric().Wrap(err)
+	}
+
+	templates, err := lookup.base.Clone()
+	if err != nil {
+		return nil, "", ErrRendererGeneric().Wrap(err)
+	}
+
+	rawTemplates, err := lookup.rawRenderer.Clone()
+	if err != nil {
+		return nil, "", ErrRendererGeneric().Wrap(err)
+	}
+
+	templates.Funcs(funcs)
+	rawTemplates.Funcs(funcs)
+
+	var w bytes.Buffer
+
+	err = self.executeIn(templates, rawTemplates, &w, name, data)
+	switch {
+	case err == nil:
+	case ErrRendererTemplateNotFound().Is(err):
+		return nil, "", err
+	default:
+		return nil, "", ErrRendererGeneric().Wrap(err)
+	}
+
+	return w.Bytes(), nonce, nil
+}
+
+// RenderDiff renders the template with the data before and after a change and returns an HTML line
+// diff of both outputs, in the configured diff format, e.g. to preview the changes of a draft.
+func (self *Renderer) RenderDiff(name string, before any, after any) ([]byte, error) {
+	beforeOutput, err := self.RenderBytes(name, before)
+	if err != nil {
+		return nil, err
+	}
+
+	afterOutput, err := self.RenderBytes(name, after)
+	if err != nil {
+		return nil, err
+	}
+
+	// SplitLines terminates every line, so a trailing newline would otherwise diff as an extra empty line
+	beforeLines := difflib.SplitLines(string(bytes.TrimSuffix(beforeOutput, []byte("\n"))))
+	afterLines := difflib.SplitLines(string(bytes.TrimSuffix(afterOutput, []byte("\n"))))
+	opCodes := difflib.NewMatcher(beforeLines, afterLines).GetOpCodes()
+
+	var w bytes.Buffer
+
+	line := func(class string, text string) {
+		fmt.Fprintf(&w, `<span class="kit-diff-%s">%s</span>`, class, html.EscapeString(text))
+	}
+
+	cell := func(class string, lines []string, i int) {
+		if i < len(lines) {
+			fmt.Fprintf(&w, `<td class="kit-diff-%s">%s</td>`, class, html.EscapeString(lines[i]))
+		} else {
+			w.WriteString(`<td></td>`)
+		}
+	}
+
+	switch *self.config.DiffFormat {
+	case RendererDiffSideBySide:
+		w.WriteString(`<table class="kit-diff">`)
+
+		for _, opCode := range opCodes {
+			removed := beforeLines[opCode.I1:opCode.I2]
+			added := afterLines[opCode.J1:opCode.J2]
+
+			for i := 0; i < max(len(removed), len(added)); i++ {
+				w.WriteString(`<tr>`)
+
+				if opCode.Tag == 'e' {
+					cell("equal", removed, i)
+					cell("equal", added, i)
+				} else {
+					cell("delete", removed, i)
+					cell("insert", added, i)
+				}
+
+				w.WriteString(`</tr>`)
+			}
+		}
+
+		w.WriteString(`</table>`)
+	default:
+		w.WriteString(`<pre class="kit-diff">`)
+
+		for _, opCode := range opCodes {
+			if opCode.Tag == 'e' {
+				for _, text := range beforeLines[opCode.I1:opCode.I2] {
+					line("equal", " "+text)
+				}
+
+				continue
+			}
+
+			for _, text := range beforeLines[opCode.I1:opCode.I2] {
+				line("delete", "-"+text)
+			}
+
+			for _, text := range afterLines[opCode.J1:opCode.J2] {
+				line("insert", "+"+text)
+			}
+		}
+
+		w.WriteString(`</pre>`)
+	}
+
+	return w.Bytes(), nil
+}
+
+// RenderMarkdown renders the template with text/template, so its markdown is not escaped, and converts
+// it to HTML. The raw HTML and dangerous links of the markdown are omitted, and then the HTML is sanitized
+// with the MarkdownSanitizer when set. Templates not listed in RawTemplates are parsed again from their
+// file into the raw namespace on their first markdown render, unless it is a .md template with Markdown
+// set, which is already converted by the render. The HTML is returned as template.HTML, a string type,
+// so the templates it is passed to do not escape it again.
+func (self *Renderer) RenderMarkdown(name string, data any) (template.HTML, error) {
+	if self.config.Markdown && filepath.Ext(name) == _RENDERER_MARKDOWN_EXTENSION {
+		output, err := self.RenderString(name, data)
+		if err != nil {
+			return "", err
+		}
+
+		return template.HTML(output), nil // nolint
+	}
+
+	var source []byte
+	var err error
+
+	if self.rawNames.Has(name) {
+		source, err = self.RenderBytes(name, data)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		source, err = self.executeMarkdown(name, data)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	output, err := self.markdown(source)
+	if err != nil {
+		return "", err
+	}
+
+	return template.HTML(output), nil // nolint
+}
+
+// executeMarkdown executes the template with text/template, parsing it again from its file into
+// the raw templates on its first markdown render, which are cached until the templates are parsed again.
+func (self *Renderer) executeMarkdown(name string, data any) ([]byte, error) {
+	self.mutex.RLock()
+	paths, markdowns := self.paths, self.markdowns
+	self.mutex.RUnlock()
+
+	rawTemplates, ok := markdowns.Load(name)
+	if !ok {
+		file, ok := paths[name]
+		if !ok {
+			return nil, ErrRendererTemplateNotFound().Withf("template %s", name)
+		}
+
+		templates, err := self.lookup(name)
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := fs.ReadFile(file.fsys, file.path)
+		if err != nil {
+			return nil, ErrRendererGeneric().Wrap(err)
+		}
+
+		markdownTemplates, err := templates.rawRenderer.Clone()
+		if err != nil {
+			return nil, ErrRendererGeneric().Wrap(err)
+		}
+
+		_, err = markdownTemplates.New(name).Parse(string(content))
+		if err != nil {
+			return nil, ErrRendererGeneric().Wrap(err)
+		}
+
+		rawTemplates, _ = markdowns.LoadOrStore(name, markdownTemplates)
+	}
+
+	var w bytes.Buffer
+
+	err := rawTemplates.(*texttemplate.Template).ExecuteTemplate(&w, name, data)
+	if err != nil {
+		return nil, ErrRendererGeneric().Wrap(err)
+	}
+
+	return w.Bytes(), nil
+}
+
+// markdown converts the markdown to HTML, omitting its raw HTML and dangerous links,
+// and sanitizes it with the MarkdownSanitizer when set.
+func (self *Renderer) markdown(source []byte) (string, error) {
+	var w bytes.Buffer
+
+	err := goldmark.Convert(source, &w)
+	if err != nil {
+		return "", ErrRendererGeneric().Wrap(err)
+	}
+
+	output := w.String()
+
+	if self.config.MarkdownSanitizer != nil {
+		output = self.config.MarkdownSanitizer(output)
+	}
+
+	return output, nil
 }
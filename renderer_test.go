@@ -0,0 +1,1564 @@
+package kit
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func _testRenderer(t *testing.T, templates fstest.MapFS, config RendererConfig) *Renderer {
+	t.Helper()
+
+	observer, err := NewObserver(context.Background(), ObserverConfig{Level: LvlDebug}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	config.TemplatesFS = templates
+
+	renderer, err := NewRenderer(*observer, config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return renderer
+}
+
+func TestRendererRenderMarkdown(t *testing.T) {
+	templates := fstest.MapFS{
+		"post.md":      {Data: []byte("**{{ .Title }}**")},
+		"raw.md":       {Data: []byte("*{{ .Title }}*")},
+		"partial.txt":  {Data: []byte("_{{ .Title }}_")},
+		"composed.txt": {Data: []byte(`{{ template "partial.txt" . }}`)},
+	}
+
+	cases := []struct {
+		name     string
+		config   RendererConfig
+		template string
+		expected string
+	}{
+		{
+			name:     "html template",
+			template: "post.md",
+			expected: "<p><strong>it's</strong></p>\n",
+		},
+		{
+			name:     "raw template",
+			config:   RendererConfig{RawTemplates: []string{"raw.md"}},
+			template: "raw.md",
+			expected: "<p><em>it's</em></p>\n",
+		},
+		{
+			name:     "raw template invoked",
+			config:   RendererConfig{RawTemplates: []string{"partial.txt"}},
+			template: "composed.txt",
+			expected: "<p><em>it's</em></p>\n",
+		},
+		{
+			name:     "lazy",
+			config:   RendererConfig{Lazy: true},
+			template: "post.md",
+			expected: "<p><strong>it's</strong></p>\n",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			renderer := _testRenderer(t, templates, c.config)
+
+			// Rendered twice to go through the cached templates
+			for i := 0; i < 2; i++ {
+				output, err := renderer.RenderMarkdown(c.template, map[string]any{"Title": "it's"})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				if string(output) != c.expected {
+					t.Errorf("expected %q, got %q", c.expected, output)
+				}
+			}
+		})
+	}
+}
+
+func TestRendererRenderMarkdownNotFound(t *testing.T) {
+	renderer := _testRenderer(t, fstest.MapFS{"post.md": {Data: []byte("post")}}, RendererConfig{})
+
+	_, err := renderer.RenderMarkdown("missing.md", nil)
+	if !ErrRendererTemplateNotFound().Is(err) {
+		t.Errorf("expected template not found, got %v", err)
+	}
+}
+
+func TestRendererMarkdownMode(t *testing.T) {
+	templates := fstest.MapFS{
+		"post.md":      {Data: []byte(`**{{ .Title }}** {{ template "footer.md" . }}`)},
+		"footer.md":    {Data: []byte("[home]({{ .URL }})")},
+		"sanitized.md": {Data: []byte("{{ .Title }}")},
+	}
+
+	cases := []struct {
+		name     string
+		template string
+		data     map[string]any
+		expected string
+	}{
+		{
+			name:     "not escaped before the conversion",
+			template: "post.md",
+			data:     map[string]any{"Title": "it's", "URL": "/?a=1&b=2"},
+			expected: "<p><strong>it's</strong> <a href=\"/?a=1&amp;b=2\">home</a></p>\n",
+		},
+		{
+			name:     "raw html omitted",
+			template: "post.md",
+			data:     map[string]any{"Title": "<script>alert(1)</script>", "URL": "javascript:alert(1)"},
+			expected: "<p><strong><!-- raw HTML omitted -->alert(1)<!-- raw HTML omitted --></strong> <a href=\"\">home</a></p>\n",
+		},
+		{
+			name:     "sanitized",
+			template: "sanitized.md",
+			data:     map[string]any{"Title": "sanitize me"},
+			expected: "sanitized",
+		},
+	}
+
+	renderer := _testRenderer(t, templates, RendererConfig{
+		Markdown: true,
+		MarkdownSanitizer: func(html string) string {
+			if html == "<p>sanitize me</p>\n" {
+				return "sanitized"
+			}
+
+			return html
+		},
+	})
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			output, err := renderer.RenderString(c.template, c.data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, output)
+			}
+
+			markdown, err := renderer.RenderMarkdown(c.template, c.data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if string(markdown) != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, markdown)
+			}
+		})
+	}
+}
+
+func TestRendererRenderWithLayout(t *testing.T) {
+	templates := fstest.MapFS{
+		"base.html": {Data: []byte(
+			`<html>{{ block "title" . }}Site{{ end }}|{{ block "content" . }}{{ end }}</html>`)},
+		"section.html": {Data: []byte(
+			`{{ template "base.html" . }}{{ define "content" }}<nav>{{ .Section }}</nav>{{ block "main" . }}{{ end }}{{ end }}`)},
+		"page.html": {Data: []byte(
+			`{{ template "section.html" . }}{{ define "title" }}{{ .Title }}{{ end }}{{ define "main" }}<p>{{ .Body }}</p>{{ end }}`)},
+		"other.html": {Data: []byte(
+			`{{ template "section.html" . }}{{ define "main" }}<p>other</p>{{ end }}`)},
+	}
+
+	data := map[string]any{"Title": "Orders", "Section": "Shop", "Body": "<b>"}
+
+	cases := []struct {
+		name     string
+		config   RendererConfig
+		layout   string
+		page     string
+		expected string
+	}{
+		{
+			name:     "page two layouts deep",
+			layout:   "base.html",
+			page:     "page.html",
+			expected: "<html>Orders|<nav>Shop</nav><p>&lt;b&gt;</p></html>",
+		},
+		{
+			name:     "page two layouts deep lazy",
+			config:   RendererConfig{Lazy: true},
+			layout:   "base.html",
+			page:     "page.html",
+			expected: "<html>Orders|<nav>Shop</nav><p>&lt;b&gt;</p></html>",
+		},
+		{
+			name:     "page one layout deep",
+			layout:   "section.html",
+			page:     "page.html",
+			expected: "<html>Orders|<nav>Shop</nav><p>&lt;b&gt;</p></html>",
+		},
+		{
+			name:     "sibling page",
+			layout:   "base.html",
+			page:     "other.html",
+			expected: "<html>Site|<nav>Shop</nav><p>other</p></html>",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			renderer := _testRenderer(t, templates, c.config)
+
+			// Rendered twice so the blocks of one render do not leak into the next one
+			for i := 0; i < 2; i++ {
+				var w strings.Builder
+
+				err := renderer.RenderWithLayout(&w, c.layout, c.page, data)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				if w.String() != c.expected {
+					t.Errorf("expected %q, got %q", c.expected, w.String())
+				}
+			}
+		})
+	}
+}
+
+type _testMoneyHelpers struct {
+	currency string
+}
+
+func (self *_testMoneyHelpers) Format(cents int) string {
+	return fmt.Sprintf("%d.%02d %s", cents/100, cents%100, self.currency)
+}
+
+func TestRendererRegisterHelpers(t *testing.T) {
+	templates := fstest.MapFS{
+		"price.html": {Data: []byte("{{ money_Format .Price }}")},
+	}
+
+	cases := []struct {
+		name     string
+		obj      any
+		expected string
+		err      bool
+	}{
+		{name: "helpers", obj: &_testMoneyHelpers{currency: "EUR"}, expected: "12.50 EUR"},
+		{name: "nil", obj: nil, err: true},
+		{name: "nil pointer", obj: (*_testMoneyHelpers)(nil), err: true},
+		{name: "invalid method", obj: &strings.Builder{}, err: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// Lazy, as the templates cannot be parsed before their helpers are registered
+			renderer := _testRenderer(t, templates, RendererConfig{Lazy: true})
+
+			err := renderer.RegisterHelpers("money", c.obj)
+
+			switch {
+			case c.err && err == nil:
+				t.Fatalf("expected the helpers to be rejected")
+			case !c.err && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case c.err:
+				return
+			}
+
+			output, err := renderer.RenderString("price.html", map[string]any{"Price": 1250})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, output)
+			}
+		})
+	}
+}
+
+func TestRendererAddFuncConcurrently(t *testing.T) {
+	renderer := _testRenderer(t, fstest.MapFS{
+		"hello.html": {Data: []byte("Hello {{ .Name }}")},
+	}, RendererConfig{})
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				output, err := renderer.RenderString("hello.html", map[string]any{"Name": "Alice"})
+				if err != nil || output != "Hello Alice" {
+					t.Errorf("expected Hello Alice, got %q: %v", output, err)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 20; i++ {
+		err := renderer.AddFunc(fmt.Sprintf("upper%d", i), strings.ToUpper)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			break
+		}
+	}
+
+	close(done)
+	wg.Wait()
+}
+
+func TestRendererRenderMarkdownEmbedded(t *testing.T) {
+	renderer := _testRenderer(t, fstest.MapFS{
+		"post.md":   {Data: []byte("**{{ .Title }}**")},
+		"page.html": {Data: []byte("<main>{{ .Post }}</main>")},
+	}, RendererConfig{})
+
+	post, err := renderer.RenderMarkdown("post.md", map[string]any{"Title": "it's"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	output, err := renderer.RenderString("page.html", map[string]any{"Post": post})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := "<main><p><strong>it's</strong></p>\n</main>"; output != expected {
+		t.Errorf("expected %q, got %q", expected, output)
+	}
+}
+
+func TestRendererRawTemplates(t *testing.T) {
+	templates := fstest.MapFS{
+		"page.html":   {Data: []byte("<p>{{ .Body }}</p>")},
+		"email.txt":   {Data: []byte(`{{ template "footer.txt" . }} {{ .Body }}`)},
+		"footer.txt":  {Data: []byte("Bye {{ .Name }}")},
+		"snippet.txt": {Data: []byte("{{ .Body }}")},
+	}
+
+	data := map[string]any{"Body": "<b>Tom & Jerry</b>", "Name": "O'Neil"}
+
+	cases := []struct {
+		name     string
+		config   RendererConfig
+		template string
+		expected string
+	}{
+		{
+			name:     "escaped",
+			template: "snippet.txt",
+			expected: "&lt;b&gt;Tom &amp; Jerry&lt;/b&gt;",
+		},
+		{
+			name:     "raw",
+			config:   RendererConfig{RawTemplates: []string{"snippet.txt"}},
+			template: "snippet.txt",
+			expected: "<b>Tom & Jerry</b>",
+		},
+		{
+			name:     "raw invoking raw",
+			config:   RendererConfig{RawTemplates: []string{"email.txt", "footer.txt"}},
+			template: "email.txt",
+			expected: "Bye O'Neil <b>Tom & Jerry</b>",
+		},
+		{
+			name:     "html next to raw",
+			config:   RendererConfig{RawTemplates: []string{"snippet.txt"}},
+			template: "page.html",
+			expected: "<p>&lt;b&gt;Tom &amp; Jerry&lt;/b&gt;</p>",
+		},
+		{
+			name:     "raw lazy",
+			config:   RendererConfig{RawTemplates: []string{"email.txt", "footer.txt"}, Lazy: true},
+			template: "email.txt",
+			expected: "Bye O'Neil <b>Tom & Jerry</b>",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			renderer := _testRenderer(t, templates, c.config)
+
+			output, err := renderer.RenderString(c.template, data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, output)
+			}
+		})
+	}
+}
+
+func TestRendererRenderResponse(t *testing.T) {
+	templates := fstest.MapFS{
+		"page.html": {Data: []byte("<p>{{ .Name }}</p>")},
+		"note.txt":  {Data: []byte("Hi {{ .Name }}")},
+		"post.md":   {Data: []byte("**{{ .Name }}**")},
+		"data.json": {Data: []byte(`{"name": "{{ .Name }}"}`)},
+		"blob.bin":  {Data: []byte("{{ .Name }}")},
+	}
+
+	cases := []struct {
+		name        string
+		config      RendererConfig
+		template    string
+		contentType string
+		body        string
+		err         bool
+	}{
+		{name: "html", template: "page.html", contentType: echo.MIMETextHTMLCharsetUTF8, body: "<p>Alice</p>"},
+		{name: "text", template: "note.txt", contentType: echo.MIMETextPlainCharsetUTF8, body: "Hi Alice"},
+		{name: "markdown", template: "post.md", contentType: _RENDERER_MIME_TEXT_MARKDOWN_CHARSET_UTF8, body: "**Alice**"},
+		{
+			name:        "markdown converted",
+			config:      RendererConfig{Markdown: true},
+			template:    "post.md",
+			contentType: echo.MIMETextHTMLCharsetUTF8,
+			body:        "<p><strong>Alice</strong></p>\n",
+		},
+		{name: "by extension", template: "data.json", contentType: "application/json", body: `{"name": "Alice"}`},
+		{name: "unknown extension", template: "blob.bin", contentType: echo.MIMEOctetStream, body: "Alice"},
+		{name: "not found", template: "missing.html", err: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.config.TemplateExtensions = regexp.MustCompile(`.*`)
+			renderer := _testRenderer(t, templates, c.config)
+
+			recorder := httptest.NewRecorder()
+			ctx := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), recorder)
+
+			err := renderer.RenderResponse(ctx, http.StatusCreated, c.template, map[string]any{"Name": "Alice"})
+			if c.err {
+				if !ErrRendererTemplateNotFound().Is(err) {
+					t.Errorf("expected template not found, got %v", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if recorder.Code != http.StatusCreated {
+				t.Errorf("expected status %d, got %d", http.StatusCreated, recorder.Code)
+			}
+
+			if actual := recorder.Header().Get(echo.HeaderContentType); actual != c.contentType {
+				t.Errorf("expected content type %q, got %q", c.contentType, actual)
+			}
+
+			if recorder.Body.String() != c.body {
+				t.Errorf("expected %q, got %q", c.body, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestRendererIcon(t *testing.T) {
+	icons := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(icons, "check.svg"), []byte(`<svg><path d="M0 0"/></svg>`), 0o600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	renderer := _testRenderer(t, fstest.MapFS{
+		"page.html": {Data: []byte(`<p>{{ icon .Icon }}</p>`)},
+	}, RendererConfig{IconsPath: ptr(icons)})
+
+	cases := []struct {
+		name     string
+		icon     string
+		expected string
+	}{
+		{name: "inlined", icon: "check", expected: `<p><svg><path d="M0 0"/></svg></p>`},
+		{
+			name:     "missing",
+			icon:     "<missing>",
+			expected: "<p>" + fmt.Sprintf(_RENDERER_ICON_PLACEHOLDER, "&lt;missing&gt;") + "</p>",
+		},
+		{
+			name:     "outside the icons path",
+			icon:     "../" + filepath.Base(icons) + "/check",
+			expected: "<p>" + fmt.Sprintf(_RENDERER_ICON_PLACEHOLDER, "../"+filepath.Base(icons)+"/check") + "</p>",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			output, err := renderer.RenderString("page.html", map[string]any{"Icon": c.icon})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, output)
+			}
+		})
+	}
+
+	t.Run("read again on refresh", func(t *testing.T) {
+		err := os.WriteFile(filepath.Join(icons, "check.svg"), []byte(`<svg/>`), 0o600)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		err = renderer.Refresh()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		output, err := renderer.RenderString("page.html", map[string]any{"Icon": "check"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if expected := "<p><svg/></p>"; output != expected {
+			t.Errorf("expected %q, got %q", expected, output)
+		}
+	})
+}
+
+func TestRendererVariant(t *testing.T) {
+	templates := fstest.MapFS{
+		"page.html":   {Data: []byte(`<main>{{ template "button.html" . }}</main>`)},
+		"button.html": {Data: []byte(`<button>{{ .Label }}</button>`)},
+		"email.txt":   {Data: []byte(`{{ template "sign.txt" . }}`)},
+		"sign.txt":    {Data: []byte(`Bye {{ .Label }}`)},
+	}
+
+	data := map[string]any{"Label": "Buy & save"}
+
+	renderer := _testRenderer(t, templates, RendererConfig{RawTemplates: []string{"email.txt", "sign.txt"}})
+
+	// Rendered before the variant, as executed templates cannot be cloned
+	original, err := renderer.RenderString("page.html", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		name     string
+		template string
+		content  string
+		render   string
+		expected string
+	}{
+		{
+			name:     "html",
+			template: "button.html",
+			content:  `<a class="cta">{{ .Label }}</a>`,
+			render:   "page.html",
+			expected: `<main><a class="cta">Buy &amp; save</a></main>`,
+		},
+		{
+			name:     "raw",
+			template: "sign.txt",
+			content:  `Cheers {{ .Label }}`,
+			render:   "email.txt",
+			expected: "Cheers Buy & save",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			variant, err := renderer.Variant(c.template, c.content)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			output, err := variant.RenderString(c.render, data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, output)
+			}
+
+			// The original renderer is left untouched
+			output, err = renderer.RenderString("page.html", data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output != original {
+				t.Errorf("expected %q, got %q", original, output)
+			}
+		})
+	}
+
+	t.Run("lazy", func(t *testing.T) {
+		lazy := _testRenderer(t, templates, RendererConfig{Lazy: true})
+
+		_, err := lazy.Variant("button.html", "<a></a>")
+		if err == nil {
+			t.Errorf("expected variants of lazy renderers to be rejected")
+		}
+	})
+}
+
+func TestRendererOverridePaths(t *testing.T) {
+	tenant, brand := t.TempDir(), t.TempDir()
+
+	files := map[string]string{
+		filepath.Join(tenant, "header.html"): "<h1>Tenant</h1>",
+		filepath.Join(tenant, "footer.html"): "<footer>Tenant</footer>",
+		filepath.Join(brand, "footer.html"):  "<footer>Brand</footer>",
+	}
+
+	for path, content := range files {
+		err := os.WriteFile(path, []byte(content), 0o600)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	templates := fstest.MapFS{
+		"page.html":   {Data: []byte(`{{ template "header.html" . }}{{ template "footer.html" . }}`)},
+		"header.html": {Data: []byte("<h1>Base</h1>")},
+		"footer.html": {Data: []byte("<footer>Base</footer>")},
+	}
+
+	cases := []struct {
+		name      string
+		overrides []string
+		expected  string
+	}{
+		{name: "none", expected: "<h1>Base</h1><footer>Base</footer>"},
+		{name: "one", overrides: []string{tenant}, expected: "<h1>Tenant</h1><footer>Tenant</footer>"},
+		{name: "later wins", overrides: []string{tenant, brand}, expected: "<h1>Tenant</h1><footer>Brand</footer>"},
+		{
+			name:      "missing skipped",
+			overrides: []string{filepath.Join(tenant, "missing"), brand},
+			expected:  "<h1>Base</h1><footer>Brand</footer>",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for _, config := range []RendererConfig{{OverridePaths: c.overrides}, {OverridePaths: c.overrides, Lazy: true}} {
+				renderer := _testRenderer(t, templates, config)
+
+				output, err := renderer.RenderString("page.html", nil)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				if output != c.expected {
+					t.Errorf("expected %q, got %q with lazy %t", c.expected, output, config.Lazy)
+				}
+			}
+		})
+	}
+}
+
+func TestRendererWarmUp(t *testing.T) {
+	templates := fstest.MapFS{
+		"page.html":  {Data: []byte("<p>{{ .Name }}</p>")},
+		"list.html":  {Data: []byte("<li>{{ index .Items 1 }}</li>")},
+		"email.txt":  {Data: []byte("Hi {{ .Name }}")},
+		"broken.txt": {Data: []byte("{{ index .Items 1 }}")},
+	}
+
+	cases := []struct {
+		name   string
+		config RendererConfig
+		data   map[string]any
+		err    bool
+	}{
+		{
+			name: "sample data",
+			data: map[string]any{
+				"list.html":  map[string]any{"Items": []string{"a", "b"}},
+				"broken.txt": map[string]any{"Items": []string{"a", "b"}},
+			},
+		},
+		{
+			name:   "sample data raw",
+			config: RendererConfig{RawTemplates: []string{"email.txt", "broken.txt"}},
+			data: map[string]any{
+				"list.html":  map[string]any{"Items": []string{"a", "b"}},
+				"broken.txt": map[string]any{"Items": []string{"a", "b"}},
+			},
+		},
+		{
+			name:   "sample data lazy",
+			config: RendererConfig{Lazy: true},
+			data: map[string]any{
+				"list.html":  map[string]any{"Items": []string{"a", "b"}},
+				"broken.txt": map[string]any{"Items": []string{"a", "b"}},
+			},
+		},
+		{name: "missing sample data", err: true},
+		{name: "missing sample data raw", config: RendererConfig{RawTemplates: []string{"broken.txt"}}, err: true},
+		{name: "missing sample data lazy", config: RendererConfig{Lazy: true}, err: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			renderer := _testRenderer(t, templates, c.config)
+
+			err := renderer.WarmUp(c.data)
+
+			switch {
+			case c.err && err == nil:
+				t.Fatalf("expected the warm up to fail")
+			case !c.err && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// Warmed up templates still render as usual
+			output, err := renderer.RenderString("page.html", map[string]any{"Name": "Alice"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if expected := "<p>Alice</p>"; output != expected {
+				t.Errorf("expected %q, got %q", expected, output)
+			}
+		})
+	}
+}
+
+func TestRendererRenderFresh(t *testing.T) {
+	templates := fstest.MapFS{
+		"page.html": {Data: []byte("<p>{{ .Name }}</p>")},
+	}
+
+	renderer := _testRenderer(t, templates, RendererConfig{})
+
+	templates["page.html"] = &fstest.MapFile{Data: []byte("<h1>{{ .Name }}</h1>")}
+
+	fresh, err := renderer.RenderFresh("page.html", map[string]any{"Name": "Alice"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := "<h1>Alice</h1>"; string(fresh) != expected {
+		t.Errorf("expected %q, got %q", expected, fresh)
+	}
+
+	// The parsed templates are left untouched
+	cached, err := renderer.RenderString("page.html", map[string]any{"Name": "Alice"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := "<p>Alice</p>"; cached != expected {
+		t.Errorf("expected %q, got %q", expected, cached)
+	}
+
+	_, err = renderer.RenderFresh("missing.html", nil)
+	if !ErrRendererTemplateNotFound().Is(err) {
+		t.Errorf("expected template not found, got %v", err)
+	}
+
+	templates["broken.html"] = &fstest.MapFile{Data: []byte("{{ if }}")}
+
+	_, err = renderer.RenderFresh("page.html", nil)
+	if err == nil {
+		t.Errorf("expected the broken template to fail the fresh render")
+	}
+}
+
+func TestRendererFallbackResolver(t *testing.T) {
+	templates := fstest.MapFS{
+		"header.html":              {Data: []byte("<h1>Base</h1>")},
+		"tenants/acme/header.html": {Data: []byte("<h1>Acme</h1>")},
+	}
+
+	resolver := func(name string) string {
+		return filepath.Base(name)
+	}
+
+	cases := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{name: "override", template: "tenants/acme/header.html", expected: "<h1>Acme</h1>"},
+		{name: "fallback", template: "tenants/other/header.html", expected: "<h1>Base</h1>"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for _, config := range []RendererConfig{{FallbackResolver: resolver}, {FallbackResolver: resolver, Lazy: true}} {
+				renderer := _testRenderer(t, templates, config)
+
+				output, err := renderer.RenderString(c.template, nil)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				if output != c.expected {
+					t.Errorf("expected %q, got %q with lazy %t", c.expected, output, config.Lazy)
+				}
+			}
+		})
+	}
+
+	t.Run("missing fallback", func(t *testing.T) {
+		for _, config := range []RendererConfig{{FallbackResolver: resolver}, {FallbackResolver: resolver, Lazy: true}} {
+			renderer := _testRenderer(t, templates, config)
+
+			_, err := renderer.RenderString("tenants/acme/footer.html", nil)
+			if !ErrRendererTemplateNotFound().Is(err) {
+				t.Errorf("expected template not found with lazy %t, got %v", config.Lazy, err)
+			}
+		}
+	})
+}
+
+func TestRendererComplexity(t *testing.T) {
+	templates := fstest.MapFS{
+		"text.html":   {Data: []byte("<p>Hi</p>")},
+		"nested.html": {Data: []byte(`{{ range .Rows }}{{ range .Cells }}{{ with .Value }}{{ . }}{{ end }}{{ end }}{{ end }}`)},
+		"flat.html":   {Data: []byte(`{{ if .A }}a{{ else }}b{{ end }}{{ range .Items }}{{ . }}{{ end }}`)},
+		"email.txt":   {Data: []byte(`{{ with .Name }}Hi {{ . }}{{ end }}`)},
+	}
+
+	cases := []struct {
+		name     string
+		template string
+		expected RendererComplexityReport
+	}{
+		{name: "text", template: "text.html", expected: RendererComplexityReport{Nodes: 2}},
+		{name: "nested", template: "nested.html", expected: RendererComplexityReport{Depth: 3, Ranges: 2, Withs: 1}},
+		{name: "flat", template: "flat.html", expected: RendererComplexityReport{Depth: 1, Ranges: 1}},
+		{name: "raw", template: "email.txt", expected: RendererComplexityReport{Depth: 1, Withs: 1}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for _, config := range []RendererConfig{{}, {Lazy: true}} {
+				renderer := _testRenderer(t, templates, config)
+
+				report, err := renderer.Complexity(c.template)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				// Only the node count of the plain text is asserted, the rest depends on the parser
+				if c.expected.Nodes == 0 {
+					report.Nodes = 0
+				}
+
+				if report != c.expected {
+					t.Errorf("expected %+v, got %+v with lazy %t", c.expected, report, config.Lazy)
+				}
+			}
+		})
+	}
+
+	renderer := _testRenderer(t, templates, RendererConfig{})
+
+	_, err := renderer.Complexity("missing.html")
+	if !ErrRendererTemplateNotFound().Is(err) {
+		t.Errorf("expected template not found, got %v", err)
+	}
+}
+
+func TestRendererCachePolicy(t *testing.T) {
+	renderer := _testRenderer(t, fstest.MapFS{
+		"landing.html":   {Data: []byte("<h1>Welcome</h1>")},
+		"dashboard.html": {Data: []byte("<h1>{{ .Name }}</h1>")},
+	}, RendererConfig{})
+
+	renderer.RegisterCachePolicy("landing.html", time.Hour, false)
+	renderer.RegisterCachePolicy("dashboard.html", 30*time.Second, true)
+
+	cases := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{name: "public", template: "landing.html", expected: "public, max-age=3600"},
+		{name: "private", template: "dashboard.html", expected: "private, max-age=30"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			policy, ok := renderer.CachePolicy(c.template)
+			if !ok {
+				t.Fatalf("expected a cache policy for %s", c.template)
+			}
+
+			if header := policy.CacheControl(); header != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, header)
+			}
+		})
+	}
+
+	if _, ok := renderer.CachePolicy("missing.html"); ok {
+		t.Errorf("expected no cache policy for an unregistered template")
+	}
+}
+
+func TestRendererRenderWithFallback(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	renderer := _testRenderer(t, fstest.MapFS{
+		"widget.html": {Data: []byte("<p>{{ .Name }}</p>")},
+		"broken.html": {Data: []byte("<p>{{ index .Items 5 }}</p>")},
+		"slow.html":   {Data: []byte("<p>{{ wait }}</p>")},
+	}, RendererConfig{
+		Funcs: template.FuncMap{
+			"wait": func() string {
+				<-release
+				return "late"
+			},
+		},
+	})
+
+	fallback := []byte("<p>Unavailable</p>")
+
+	cases := []struct {
+		name     string
+		template string
+		data     any
+		expected string
+	}{
+		{name: "rendered", template: "widget.html", data: map[string]any{"Name": "Alice"}, expected: "<p>Alice</p>"},
+		{name: "failed", template: "broken.html", data: map[string]any{"Items": []int{}}, expected: string(fallback)},
+		{name: "missing", template: "missing.html", expected: string(fallback)},
+		{name: "exceeded", template: "slow.html", expected: string(fallback)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			output := renderer.RenderWithFallback(context.Background(), c.template, c.data, 50*time.Millisecond, fallback)
+			if string(output) != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, output)
+			}
+		})
+	}
+}
+
+func TestRendererRenderStream(t *testing.T) {
+	renderer := _testRenderer(t, fstest.MapFS{
+		"page.html": {Data: []byte(`<main>{{ .Title }}{{ defer "recs" }}</main>`)},
+		"recs.html": {Data: []byte("<ul><li>{{ .Item }}</li></ul>")},
+	}, RendererConfig{})
+
+	t.Run("slots", func(t *testing.T) {
+		slots := make(chan RendererSlot, 1)
+		slots <- RendererSlot{Name: "recs", Template: "recs.html", Data: map[string]any{"Item": "Book"}}
+		close(slots)
+
+		recorder := httptest.NewRecorder()
+
+		err := renderer.RenderStream(context.Background(), recorder, "page.html", map[string]any{"Title": "Home"}, slots)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !recorder.Flushed {
+			t.Errorf("expected the stream to be flushed")
+		}
+
+		expected := []string{
+			`<main>Home<div id="kit-slot-recs" hidden></div></main>`,
+			`<template id="kit-slot-content-recs"><ul><li>Book</li></ul></template>`,
+		}
+
+		for _, part := range expected {
+			if !strings.Contains(recorder.Body.String(), part) {
+				t.Errorf("expected %q in %q", part, recorder.Body.String())
+			}
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		recorder := httptest.NewRecorder()
+
+		err := renderer.RenderStream(ctx, recorder, "page.html", nil, make(chan RendererSlot))
+		if err == nil {
+			t.Fatalf("expected the canceled stream to fail")
+		}
+
+		// The page is still rendered before waiting for the slots
+		if !strings.Contains(recorder.Body.String(), `<div id="kit-slot-recs" hidden></div>`) {
+			t.Errorf("expected the page in %q", recorder.Body.String())
+		}
+	})
+
+	t.Run("missing slot template", func(t *testing.T) {
+		slots := make(chan RendererSlot, 1)
+		slots <- RendererSlot{Name: "recs", Template: "missing.html"}
+
+		err := renderer.RenderStream(context.Background(), httptest.NewRecorder(), "page.html", nil, slots)
+		if !ErrRendererGeneric().Is(err) {
+			t.Errorf("expected renderer generic, got %v", err)
+		}
+	})
+}
+
+func TestRendererRenderWithNonce(t *testing.T) {
+	renderer := _testRenderer(t, fstest.MapFS{
+		"page.html": {Data: []byte(`<script nonce="{{ nonce }}">{{ .Script }}</script>`)},
+		"style.txt": {Data: []byte(`nonce={{ nonce }}`)},
+	}, RendererConfig{})
+
+	cases := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{name: "html", template: "page.html", expected: `<script nonce="%s">"use strict"</script>`},
+		{name: "raw", template: "style.txt", expected: "nonce=%s"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			output, nonce, err := renderer.RenderWithNonce(c.template, map[string]any{"Script": "use strict"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if expected := fmt.Sprintf(c.expected, nonce); string(output) != expected {
+				t.Errorf("expected %q, got %q", expected, output)
+			}
+
+			_, other, err := renderer.RenderWithNonce(c.template, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if nonce == "" || other == nonce {
+				t.Errorf("expected a fresh nonce per render, got %q and %q", nonce, other)
+			}
+		})
+	}
+
+	// The nonce is only bound when rendering with RenderWithNonce
+	_, err := renderer.RenderString("page.html", nil)
+	if err == nil {
+		t.Errorf("expected the nonce to fail outside RenderWithNonce")
+	}
+
+	_, _, err = renderer.RenderWithNonce("missing.html", nil)
+	if !ErrRendererTemplateNotFound().Is(err) {
+		t.Errorf("expected template not found, got %v", err)
+	}
+}
+
+func TestRendererRenderDiff(t *testing.T) {
+	templates := fstest.MapFS{
+		"post.txt": {Data: []byte("{{ .Title }}\n<b>by</b>\n{{ .Author }}\n")},
+	}
+
+	before := map[string]any{"Title": "Draft", "Author": "Alice"}
+	after := map[string]any{"Title": "Post", "Author": "Alice"}
+
+	cases := []struct {
+		name     string
+		format   *RendererDiffFormat
+		expected string
+	}{
+		{
+			name: "unified",
+			expected: `<pre class="kit-diff"><span class="kit-diff-delete">-Draft` + "\n" + `</span>` +
+				`<span class="kit-diff-insert">+Post` + "\n" + `</span>` +
+				`<span class="kit-diff-equal"> &lt;b&gt;by&lt;/b&gt;` + "\n" + `</span>` +
+				`<span class="kit-diff-equal"> Alice` + "\n" + `</span></pre>`,
+		},
+		{
+			name:   "side by side",
+			format: ptr(RendererDiffSideBySide),
+			expected: `<table class="kit-diff">` +
+				`<tr><td class="kit-diff-delete">Draft` + "\n" + `</td><td class="kit-diff-insert">Post` + "\n" + `</td></tr>` +
+				`<tr><td class="kit-diff-equal">&lt;b&gt;by&lt;/b&gt;` + "\n" + `</td>` +
+				`<td class="kit-diff-equal">&lt;b&gt;by&lt;/b&gt;` + "\n" + `</td></tr>` +
+				`<tr><td class="kit-diff-equal">Alice` + "\n" + `</td><td class="kit-diff-equal">Alice` + "\n" + `</td></tr>` +
+				`</table>`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			renderer := _testRenderer(t, templates, RendererConfig{DiffFormat: c.format})
+
+			diff, err := renderer.RenderDiff("post.txt", before, after)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if string(diff) != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, diff)
+			}
+		})
+	}
+
+	renderer := _testRenderer(t, templates, RendererConfig{})
+
+	_, err := renderer.RenderDiff("missing.txt", before, after)
+	if !ErrRendererTemplateNotFound().Is(err) {
+		t.Errorf("expected template not found, got %v", err)
+	}
+}
+
+func TestRendererLazy(t *testing.T) {
+	templates := fstest.MapFS{
+		"page.html":   {Data: []byte(`{{ if .Show }}{{ template "header.html" . }}{{ end }}<p>{{ .Name }}</p>`)},
+		"header.html": {Data: []byte(`<h1>{{ template "title.html" . }}</h1>`)},
+		"title.html":  {Data: []byte("Home")},
+		"broken.html": {Data: []byte("{{ if }}")},
+	}
+
+	observer, err := NewObserver(context.Background(), ObserverConfig{Level: LvlDebug}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = NewRenderer(*observer, RendererConfig{TemplatesFS: templates})
+	if err == nil {
+		t.Fatalf("expected the eager renderer to fail parsing the broken template")
+	}
+
+	renderer := _testRenderer(t, templates, RendererConfig{Lazy: true})
+
+	// The templates invoked are parsed along with the rendered one
+	output, err := renderer.RenderString("page.html", map[string]any{"Show": true, "Name": "Alice"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := "<h1>Home</h1><p>Alice</p>"; output != expected {
+		t.Errorf("expected %q, got %q", expected, output)
+	}
+
+	_, err = renderer.RenderString("broken.html", nil)
+	if err == nil {
+		t.Errorf("expected the broken template to fail on its first render")
+	}
+
+	// Parsed templates are cached until refreshed
+	templates["title.html"] = &fstest.MapFile{Data: []byte("Welcome")}
+	delete(templates, "broken.html")
+
+	output, err = renderer.RenderString("header.html", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := "<h1>Welcome</h1>"; output != expected {
+		t.Errorf("expected %q for a template not rendered before, got %q", expected, output)
+	}
+
+	output, err = renderer.RenderString("page.html", map[string]any{"Show": true, "Name": "Alice"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := "<h1>Home</h1><p>Alice</p>"; output != expected {
+		t.Errorf("expected %q from the cache, got %q", expected, output)
+	}
+
+	err = renderer.Refresh()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	output, err = renderer.RenderString("page.html", map[string]any{"Show": true, "Name": "Alice"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := "<h1>Welcome</h1><p>Alice</p>"; output != expected {
+		t.Errorf("expected %q after refreshing, got %q", expected, output)
+	}
+}
+
+func TestRendererRequireTemplates(t *testing.T) {
+	observer, err := NewObserver(context.Background(), ObserverConfig{Level: LvlDebug}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		name      string
+		templates fstest.MapFS
+		require   bool
+		lazy      bool
+		err       bool
+	}{
+		{name: "lenient", templates: fstest.MapFS{}},
+		{name: "required", templates: fstest.MapFS{}, require: true, err: true},
+		{name: "required lazy", templates: fstest.MapFS{}, require: true, lazy: true, err: true},
+		{
+			name:      "filtered",
+			templates: fstest.MapFS{"notes.bak": {Data: []byte("Notes")}},
+			require:   true,
+			err:       true,
+		},
+		{name: "found", templates: fstest.MapFS{"page.html": {Data: []byte("<p>Hi</p>")}}, require: true},
+		{name: "found lazy", templates: fstest.MapFS{"page.html": {Data: []byte("<p>Hi</p>")}}, require: true, lazy: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := NewRenderer(*observer, RendererConfig{TemplatesFS: c.templates, RequireTemplates: c.require, Lazy: c.lazy})
+			if (err != nil) != c.err {
+				t.Errorf("expected error %t, got %v", c.err, err)
+			}
+		})
+	}
+}
+
+func TestRendererAsset(t *testing.T) {
+	manifest := filepath.Join(t.TempDir(), "manifest.json")
+
+	err := os.WriteFile(manifest, []byte(`{"app.css": "/static/app.abc123.css", "app.js": "/static/app.def456.js"}`), 0o600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	templates := fstest.MapFS{
+		"page.html": {Data: []byte(`<link href="{{ asset "app.css" }}"><script src="{{ asset .Script }}"></script>`)},
+	}
+
+	cases := []struct {
+		name     string
+		config   RendererConfig
+		script   string
+		expected string
+	}{
+		{
+			name:     "manifest",
+			config:   RendererConfig{AssetManifest: map[string]string{"app.css": "/static/app.abc123.css"}},
+			script:   "vendor.js",
+			expected: `<link href="/static/app.abc123.css"><script src="vendor.js"></script>`,
+		},
+		{
+			name:     "manifest file",
+			config:   RendererConfig{AssetManifestPath: &manifest},
+			script:   "app.js",
+			expected: `<link href="/static/app.abc123.css"><script src="/static/app.def456.js"></script>`,
+		},
+		{
+			name: "manifest over file",
+			config: RendererConfig{
+				AssetManifestPath: &manifest,
+				AssetManifest:     map[string]string{"app.js": "/static/app.789abc.js"},
+			},
+			script:   "app.js",
+			expected: `<link href="/static/app.abc123.css"><script src="/static/app.789abc.js"></script>`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			renderer := _testRenderer(t, templates, c.config)
+
+			output, err := renderer.RenderString("page.html", map[string]any{"Script": c.script})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, output)
+			}
+		})
+	}
+
+	observer, err := NewObserver(context.Background(), ObserverConfig{Level: LvlDebug}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing.json")
+
+	_, err = NewRenderer(*observer, RendererConfig{TemplatesFS: templates, AssetManifestPath: &missing})
+	if err == nil {
+		t.Errorf("expected a missing manifest file to fail")
+	}
+}
+
+func TestRendererMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+
+	renderer := _testRenderer(t, fstest.MapFS{
+		"page.html": {Data: []byte("<p>{{ .Name }}</p>")},
+		"list.html": {Data: []byte("{{ range .Items }}<li>{{ . }}</li>{{ end }}")},
+	}, RendererConfig{MetricsRegisterer: registry})
+
+	renders := []struct {
+		template string
+		data     any
+	}{
+		{template: "page.html", data: map[string]any{"Name": "Alice"}},
+		{template: "page.html", data: map[string]any{"Name": "Bob"}},
+		{template: "list.html", data: map[string]any{"Items": []string{"a", "b", "c"}}},
+	}
+
+	for _, render := range renders {
+		_, err := renderer.RenderBytes(render.template, render.data)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The byte sizes of "<p>Alice</p>" and "<p>Bob</p>", and of the three list items
+	expected := map[string]map[string][2]float64{
+		"kit_renderer_render_duration_seconds": {"page.html": {2, -1}, "list.html": {1, -1}},
+		"kit_renderer_render_size_bytes":       {"page.html": {2, 22}, "list.html": {1, 30}},
+	}
+
+	for _, family := range families {
+		templates, ok := expected[family.GetName()]
+		if !ok {
+			continue
+		}
+
+		delete(expected, family.GetName())
+
+		for _, metric := range family.GetMetric() {
+			template := metric.GetLabel()[0].GetValue()
+			histogram := metric.GetHistogram()
+
+			count, sum := templates[template][0], templates[template][1]
+			if float64(histogram.GetSampleCount()) != count || (sum >= 0 && histogram.GetSampleSum() != sum) {
+				t.Errorf("expected %s of %s with count %v and sum %v, got %d and %v", family.GetName(), template,
+					count, sum, histogram.GetSampleCount(), histogram.GetSampleSum())
+			}
+		}
+	}
+
+	if len(expected) > 0 {
+		t.Errorf("expected the %v histograms to be registered", expected)
+	}
+}
+
+func TestRendererFuncs(t *testing.T) {
+	templates := fstest.MapFS{
+		"page.html": {Data: []byte("<p>{{ upper .Name }}</p>")},
+		"email.txt": {Data: []byte("Hi {{ upper .Name }}")},
+	}
+
+	for _, lazy := range []bool{false, true} {
+		renderer := _testRenderer(t, templates, RendererConfig{
+			Funcs: template.FuncMap{"upper": strings.ToUpper},
+			Lazy:  lazy,
+		})
+
+		for name, expected := range map[string]string{"page.html": "<p>ALICE</p>", "email.txt": "Hi ALICE"} {
+			output, err := renderer.RenderString(name, map[string]any{"Name": "Alice"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output != expected {
+				t.Errorf("expected %q, got %q with lazy %t", expected, output, lazy)
+			}
+		}
+	}
+
+	observer, err := NewObserver(context.Background(), ObserverConfig{Level: LvlDebug}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The functions must exist before parsing the templates
+	_, err = NewRenderer(*observer, RendererConfig{TemplatesFS: templates})
+	if err == nil {
+		t.Errorf("expected the undefined function to fail parsing")
+	}
+
+	invalid := []any{"upper", func() {}, func() (string, string) { return "", "" }}
+	for _, fn := range invalid {
+		_, err = NewRenderer(*observer, RendererConfig{
+			TemplatesFS: fstest.MapFS{},
+			Funcs:       template.FuncMap{"invalid": fn},
+		})
+		if err == nil {
+			t.Errorf("expected the invalid function %T to fail", fn)
+		}
+	}
+
+	renderer := _testRenderer(t, fstest.MapFS{"other.html": {Data: []byte("<p>{{ .Name }}</p>")}}, RendererConfig{})
+
+	err = renderer.AddFunc("invalid", "upper")
+	if err == nil {
+		t.Errorf("expected the invalid function to fail")
+	}
+}
+
+func TestRendererRefresh(t *testing.T) {
+	templates := fstest.MapFS{
+		"page.html": {Data: []byte("<p>{{ .Name }}</p>")},
+	}
+
+	renderer := _testRenderer(t, templates, RendererConfig{})
+
+	render := func(expected string) {
+		t.Helper()
+
+		output, err := renderer.RenderString("page.html", map[string]any{"Name": "Alice"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if output != expected {
+			t.Errorf("expected %q, got %q", expected, output)
+		}
+	}
+
+	templates["page.html"] = &fstest.MapFile{Data: []byte("<h1>{{ .Name }}</h1>")}
+	templates["new.html"] = &fstest.MapFile{Data: []byte("<em>New</em>")}
+
+	err := renderer.Refresh()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	render("<h1>Alice</h1>")
+
+	output, err := renderer.RenderString("new.html", nil)
+	if err != nil || output != "<em>New</em>" {
+		t.Errorf("expected the new template, got %q: %v", output, err)
+	}
+
+	// A broken edit keeps the previous templates rendering
+	templates["page.html"] = &fstest.MapFile{Data: []byte("<h2>{{ .Name }}</h2>")}
+	templates["broken.html"] = &fstest.MapFile{Data: []byte("{{ if }}")}
+
+	err = renderer.Refresh()
+	if err == nil {
+		t.Fatalf("expected the broken template to fail the refresh")
+	}
+
+	render("<h1>Alice</h1>")
+
+	var wg sync.WaitGroup
+
+	delete(templates, "broken.html")
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 20; j++ {
+				output, err := renderer.RenderString("page.html", map[string]any{"Name": "Alice"})
+				if err != nil || (output != "<h1>Alice</h1>" && output != "<h2>Alice</h2>") {
+					t.Errorf("unexpected render %q: %v", output, err)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 5; i++ {
+		err = renderer.Refresh()
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	wg.Wait()
+
+	render("<h2>Alice</h2>")
+}
+
+func TestRendererTemplatesFS(t *testing.T) {
+	files := map[string]string{
+		"page.html":            `<main>{{ template "partials/header.html" . }}</main>`,
+		"partials/header.html": "<h1>{{ .Title }}</h1>",
+		"emails/welcome.txt":   "Welcome {{ .Title }}",
+	}
+
+	path := t.TempDir()
+	templates := fstest.MapFS{}
+
+	for name, content := range files {
+		templates[name] = &fstest.MapFile{Data: []byte(content)}
+
+		err := os.MkdirAll(filepath.Join(path, filepath.Dir(name)), 0o755)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		err = os.WriteFile(filepath.Join(path, filepath.FromSlash(name)), []byte(content), 0o600)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	observer, err := NewObserver(context.Background(), ObserverConfig{Level: LvlDebug}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	disk, err := NewRenderer(*observer, RendererConfig{TemplatesPath: &path})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The FS wins over the path
+	missing := filepath.Join(path, "missing")
+	embedded := _testRenderer(t, templates, RendererConfig{TemplatesPath: &missing})
+
+	expected := map[string]string{
+		"page.html":            "<main><h1>Home</h1></main>",
+		"partials/header.html": "<h1>Home</h1>",
+		"emails/welcome.txt":   "Welcome Home",
+	}
+
+	// The templates are named the same from the disk and from the FS
+	for name, content := range expected {
+		for source, renderer := range map[string]*Renderer{"disk": disk, "fs": embedded} {
+			output, err := renderer.RenderString(name, map[string]any{"Title": "Home"})
+			if err != nil {
+				t.Fatalf("unexpected error from the %s: %v", source, err)
+			}
+
+			if output != content {
+				t.Errorf("expected %q from the %s, got %q", content, source, output)
+			}
+		}
+	}
+}